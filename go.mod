@@ -4,9 +4,9 @@ go 1.12
 
 require (
 	github.com/aws/aws-lambda-go v1.13.3
-	github.com/aws/aws-sdk-go v1.19.21
+	github.com/aws/aws-sdk-go v1.44.300
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
 	github.com/spf13/cobra v0.0.3
 	github.com/spf13/pflag v1.0.3 // indirect
-	golang.org/x/net v0.0.0-20190501004415-9ce7a6920f09 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,288 @@
+package amazon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+)
+
+// UsagePlan is the throttling and quota applied to the api keys of a lambda, nil fields keep the values of an existing
+// plan or take the defaults on creation. A quota of 0 means no quota.
+type UsagePlan struct {
+	Rate   *float64
+	Burst  *int64
+	Quota  *int64
+	Period *string
+}
+
+// Defaults of a created usage plan
+const (
+	usagePlanRate   = 10
+	usagePlanBurst  = 20
+	usagePlanPeriod = "MONTH"
+)
+
+func usagePlanName(name string) string {
+	return fmt.Sprintf("%s-plan", name)
+}
+
+// usagePlanOperations patch only the limits given to the existing usage plan, a quota of 0 removes its quota
+func usagePlanOperations(usagePlan *apigateway.UsagePlan, plan UsagePlan) []*apigateway.PatchOperation {
+	replace := func(path, value string) *apigateway.PatchOperation {
+		return &apigateway.PatchOperation{Op: aws.String("replace"), Path: aws.String(path), Value: aws.String(value)}
+	}
+
+	var operations []*apigateway.PatchOperation
+	if plan.Rate != nil {
+		operations = append(operations, replace("/throttle/rateLimit", strconv.FormatFloat(*plan.Rate, 'f', -1, 64)))
+	}
+	if plan.Burst != nil {
+		operations = append(operations, replace("/throttle/burstLimit", strconv.FormatInt(*plan.Burst, 10)))
+	}
+
+	switch {
+	case plan.Quota != nil && *plan.Quota == 0:
+		if usagePlan.Quota != nil {
+			operations = append(operations, &apigateway.PatchOperation{Op: aws.String("remove"), Path: aws.String("/quota")})
+		}
+	case plan.Quota != nil:
+		period := usagePlanPeriod
+		if usagePlan.Quota != nil {
+			period = aws.StringValue(usagePlan.Quota.Period)
+		}
+		if plan.Period != nil {
+			period = *plan.Period
+		}
+		operations = append(operations,
+			replace("/quota/limit", strconv.FormatInt(*plan.Quota, 10)),
+			replace("/quota/period", period),
+		)
+	case plan.Period != nil && usagePlan.Quota != nil:
+		operations = append(operations, replace("/quota/period", *plan.Period))
+	}
+	return operations
+}
+
+// ApiKeyCreate create an api key for the lambda, the usage plan is created or updated with the given limits only.
+// The value of the key is only available in the returned key.
+func ApiKeyCreate(sess *session.Session, name string, plan UsagePlan) (*apigateway.ApiKey, error) {
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return nil, err
+	}
+	if api == nil {
+		return nil, errors.New("no api gateway found for this lambda")
+	}
+
+	gateway := apigateway.New(sess)
+
+	usagePlan, err := usagePlanGet(sess, name)
+	if err != nil {
+		return nil, err
+	}
+	if usagePlan == nil {
+		stages, err := StageList(sess, name)
+		if err != nil {
+			return nil, err
+		}
+		var apiStages []*apigateway.ApiStage
+		for _, s := range stages {
+			apiStages = append(apiStages, &apigateway.ApiStage{ApiId: api.Id, Stage: aws.String(s.Name)})
+		}
+
+		input := &apigateway.CreateUsagePlanInput{
+			Name:      aws.String(usagePlanName(name)),
+			ApiStages: apiStages,
+			Throttle: &apigateway.ThrottleSettings{
+				RateLimit:  aws.Float64(usagePlanRate),
+				BurstLimit: aws.Int64(usagePlanBurst),
+			},
+		}
+		if plan.Rate != nil {
+			input.Throttle.RateLimit = plan.Rate
+		}
+		if plan.Burst != nil {
+			input.Throttle.BurstLimit = plan.Burst
+		}
+		if aws.Int64Value(plan.Quota) > 0 {
+			input.Quota = &apigateway.QuotaSettings{
+				Limit:  plan.Quota,
+				Period: aws.String(usagePlanPeriod),
+			}
+			if plan.Period != nil {
+				input.Quota.Period = plan.Period
+			}
+		}
+		usagePlan, err = gateway.CreateUsagePlan(input)
+		if err != nil {
+			return nil, err
+		}
+	} else if operations := usagePlanOperations(usagePlan, plan); len(operations) > 0 {
+		_, err = gateway.UpdateUsagePlan(&apigateway.UpdateUsagePlanInput{
+			UsagePlanId:     usagePlan.Id,
+			PatchOperations: operations,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := gateway.CreateApiKey(&apigateway.CreateApiKeyInput{
+		Name:        aws.String(fmt.Sprintf("%s-%s", name, util.RandID(6))),
+		Description: aws.String("Created by awsl"),
+		Enabled:     aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = gateway.CreateUsagePlanKey(&apigateway.CreateUsagePlanKeyInput{
+		KeyId:       key.Id,
+		KeyType:     aws.String("API_KEY"),
+		UsagePlanId: usagePlan.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ApiKeyList return the api keys of the lambda without their values
+func ApiKeyList(sess *session.Session, name string) ([]*apigateway.ApiKey, error) {
+	usagePlan, err := usagePlanGet(sess, name)
+	if err != nil || usagePlan == nil {
+		return nil, err
+	}
+
+	gateway := apigateway.New(sess)
+
+	var keys []*apigateway.ApiKey
+	var errx error
+	err = gateway.GetUsagePlanKeysPages(&apigateway.GetUsagePlanKeysInput{
+		UsagePlanId: usagePlan.Id,
+	}, func(output *apigateway.GetUsagePlanKeysOutput, _ bool) bool {
+		for _, k := range output.Items {
+			key, err := gateway.GetApiKey(&apigateway.GetApiKeyInput{ApiKey: k.Id})
+			if err != nil {
+				errx = err
+				return false
+			}
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, errx
+}
+
+// ApiKeyRevoke detach the api key from the usage plan of the lambda and delete it
+func ApiKeyRevoke(sess *session.Session, name, keyId string) error {
+	usagePlan, err := usagePlanGet(sess, name)
+	if err != nil {
+		return err
+	}
+	if usagePlan == nil {
+		return errors.New("no api key found for this lambda")
+	}
+
+	gateway := apigateway.New(sess)
+	_, err = gateway.DeleteUsagePlanKey(&apigateway.DeleteUsagePlanKeyInput{
+		KeyId:       aws.String(keyId),
+		UsagePlanId: usagePlan.Id,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = gateway.DeleteApiKey(&apigateway.DeleteApiKeyInput{
+		ApiKey: aws.String(keyId),
+	})
+	return err
+}
+
+// usagePlanAddStage make the api keys of the lambda usable on the stage, nothing is done if no key was created
+func usagePlanAddStage(sess *session.Session, name string, apiId *string, stage string) error {
+	usagePlan, err := usagePlanGet(sess, name)
+	if err != nil || usagePlan == nil {
+		return err
+	}
+	for _, s := range usagePlan.ApiStages {
+		if aws.StringValue(s.ApiId) == *apiId && aws.StringValue(s.Stage) == stage {
+			return nil
+		}
+	}
+	_, err = apigateway.New(sess).UpdateUsagePlan(&apigateway.UpdateUsagePlanInput{
+		UsagePlanId: usagePlan.Id,
+		PatchOperations: []*apigateway.PatchOperation{{
+			Op:    aws.String("add"),
+			Path:  aws.String("/apiStages"),
+			Value: aws.String(fmt.Sprintf("%s:%s", *apiId, stage)),
+		}},
+	})
+	return err
+}
+
+// usagePlanDelete revoke every api key of the lambda and delete its usage plan
+func usagePlanDelete(sess *session.Session, name string) error {
+	usagePlan, err := usagePlanGet(sess, name)
+	if err != nil || usagePlan == nil {
+		return err
+	}
+
+	keys, err := ApiKeyList(sess, name)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := ApiKeyRevoke(sess, name, aws.StringValue(k.Id)); err != nil {
+			return err
+		}
+	}
+
+	gateway := apigateway.New(sess)
+
+	// A usage plan can't be deleted while it is associated to a stage
+	var operations []*apigateway.PatchOperation
+	for _, s := range usagePlan.ApiStages {
+		operations = append(operations, &apigateway.PatchOperation{
+			Op:    aws.String("remove"),
+			Path:  aws.String("/apiStages"),
+			Value: aws.String(fmt.Sprintf("%s:%s", aws.StringValue(s.ApiId), aws.StringValue(s.Stage))),
+		})
+	}
+	if len(operations) > 0 {
+		_, err = gateway.UpdateUsagePlan(&apigateway.UpdateUsagePlanInput{
+			UsagePlanId:     usagePlan.Id,
+			PatchOperations: operations,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = gateway.DeleteUsagePlan(&apigateway.DeleteUsagePlanInput{
+		UsagePlanId: usagePlan.Id,
+	})
+	return err
+}
+
+func usagePlanGet(sess *session.Session, name string) (*apigateway.UsagePlan, error) {
+	var usagePlan *apigateway.UsagePlan
+	err := apigateway.New(sess).GetUsagePlansPages(&apigateway.GetUsagePlansInput{}, func(output *apigateway.GetUsagePlansOutput, _ bool) bool {
+		for _, p := range output.Items {
+			if aws.StringValue(p.Name) == usagePlanName(name) {
+				usagePlan = p
+				return false
+			}
+		}
+		return true
+	})
+	return usagePlan, err
+}
@@ -0,0 +1,112 @@
+package amazon
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Account is where a session operate, every arn and url built by awsl derive from it
+type Account struct {
+	Partition string
+	Region    string
+	Id        string
+}
+
+var (
+	accountsMutex sync.Mutex
+	accounts      = map[*session.Session]*Account{}
+)
+
+// AccountGet return the account of the session, it is only asked to aws once per session
+func AccountGet(sess *session.Session) (*Account, error) {
+	accountsMutex.Lock()
+	defer accountsMutex.Unlock()
+
+	if a, ok := accounts[sess]; ok {
+		return a, nil
+	}
+
+	output, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	region := aws.StringValue(sess.Config.Region)
+	a := &Account{
+		Partition: partitionForRegion(region),
+		Region:    region,
+		Id:        aws.StringValue(output.Account),
+	}
+	accounts[sess] = a
+	return a, nil
+}
+
+func partitionForRegion(region string) string {
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return p.ID()
+	}
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return endpoints.AwsCnPartitionID
+	case strings.HasPrefix(region, "us-gov-"):
+		return endpoints.AwsUsGovPartitionID
+	}
+	return endpoints.AwsPartitionID
+}
+
+// dnsSuffix is the domain of the public endpoints of the partition
+func (a *Account) dnsSuffix() string {
+	if a.Partition == endpoints.AwsCnPartitionID {
+		return "amazonaws.com.cn"
+	}
+	return "amazonaws.com"
+}
+
+// LambdaInvocationUri is the uri used by api gateway to invoke a lambda
+func (a *Account) LambdaInvocationUri(functionArn string) string {
+	return fmt.Sprintf("arn:%s:apigateway:%s:lambda:path/2015-03-31/functions/%s/invocations", a.Partition, a.Region, functionArn)
+}
+
+// ExecuteApiArn is the arn of the resources of a rest api matching the path
+func (a *Account) ExecuteApiArn(apiId, path string) string {
+	return fmt.Sprintf("arn:%s:execute-api:%s:%s:%s/%s", a.Partition, a.Region, a.Id, apiId, path)
+}
+
+// ExecuteApiUrl is the public url of a stage of a rest api
+func (a *Account) ExecuteApiUrl(apiId, stage, path string) string {
+	return fmt.Sprintf("https://%s.execute-api.%s.%s/%s/%s", apiId, a.Region, a.dnsSuffix(), stage, path)
+}
+
+// ManagedPolicyArn is the arn of a policy managed by aws
+func (a *Account) ManagedPolicyArn(name string) string {
+	return fmt.Sprintf("arn:%s:iam::aws:policy/%s", a.Partition, name)
+}
+
+// RestApiArn is the arn of a rest api, used to tag it
+func (a *Account) RestApiArn(apiId string) string {
+	return fmt.Sprintf("arn:%s:apigateway:%s::/restapis/%s", a.Partition, a.Region, apiId)
+}
+
+// QueueArn is the arn of a sqs queue given by its name or arn
+func (a *Account) QueueArn(queue string) string {
+	if strings.HasPrefix(queue, "arn:") {
+		return queue
+	}
+	return fmt.Sprintf("arn:%s:sqs:%s:%s:%s", a.Partition, a.Region, a.Id, queue)
+}
+
+// FunctionArn is the unqualified arn of a function, known even when the function is already deleted
+func (a *Account) FunctionArn(name string) string {
+	return fmt.Sprintf("arn:%s:lambda:%s:%s:function:%s", a.Partition, a.Region, a.Id, name)
+}
+
+// BucketArn is the arn of a s3 bucket
+func (a *Account) BucketArn(bucket string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", a.Partition, bucket)
+}
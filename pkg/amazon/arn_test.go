@@ -0,0 +1,82 @@
+package amazon
+
+import "testing"
+
+func TestAccountArns(t *testing.T) {
+	tests := []struct {
+		region    string
+		partition string
+
+		invocationUri string
+		executeApiArn string
+		executeApiUrl string
+		managedPolicy string
+		restApiArn    string
+		queueArn      string
+		bucketArn     string
+	}{
+		{
+			region:        "eu-west-3",
+			partition:     "aws",
+			invocationUri: "arn:aws:apigateway:eu-west-3:lambda:path/2015-03-31/functions/arn:aws:lambda:eu-west-3:123456789012:function:hello-abc/invocations",
+			executeApiArn: "arn:aws:execute-api:eu-west-3:123456789012:api123/*/*/hello-abc",
+			executeApiUrl: "https://api123.execute-api.eu-west-3.amazonaws.com/default/hello-abc",
+			managedPolicy: "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole",
+			restApiArn:    "arn:aws:apigateway:eu-west-3::/restapis/api123",
+			queueArn:      "arn:aws:sqs:eu-west-3:123456789012:jobs",
+			bucketArn:     "arn:aws:s3:::hello-abc",
+		},
+		{
+			region:        "cn-north-1",
+			partition:     "aws-cn",
+			invocationUri: "arn:aws-cn:apigateway:cn-north-1:lambda:path/2015-03-31/functions/arn:aws-cn:lambda:cn-north-1:123456789012:function:hello-abc/invocations",
+			executeApiArn: "arn:aws-cn:execute-api:cn-north-1:123456789012:api123/*/*/hello-abc",
+			executeApiUrl: "https://api123.execute-api.cn-north-1.amazonaws.com.cn/default/hello-abc",
+			managedPolicy: "arn:aws-cn:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole",
+			restApiArn:    "arn:aws-cn:apigateway:cn-north-1::/restapis/api123",
+			queueArn:      "arn:aws-cn:sqs:cn-north-1:123456789012:jobs",
+			bucketArn:     "arn:aws-cn:s3:::hello-abc",
+		},
+		{
+			region:        "us-gov-west-1",
+			partition:     "aws-us-gov",
+			invocationUri: "arn:aws-us-gov:apigateway:us-gov-west-1:lambda:path/2015-03-31/functions/arn:aws-us-gov:lambda:us-gov-west-1:123456789012:function:hello-abc/invocations",
+			executeApiArn: "arn:aws-us-gov:execute-api:us-gov-west-1:123456789012:api123/*/*/hello-abc",
+			executeApiUrl: "https://api123.execute-api.us-gov-west-1.amazonaws.com/default/hello-abc",
+			managedPolicy: "arn:aws-us-gov:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole",
+			restApiArn:    "arn:aws-us-gov:apigateway:us-gov-west-1::/restapis/api123",
+			queueArn:      "arn:aws-us-gov:sqs:us-gov-west-1:123456789012:jobs",
+			bucketArn:     "arn:aws-us-gov:s3:::hello-abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.partition, func(t *testing.T) {
+			if p := partitionForRegion(tt.region); p != tt.partition {
+				t.Fatalf("partition of %s: got %s, want %s", tt.region, p, tt.partition)
+			}
+			a := &Account{Partition: tt.partition, Region: tt.region, Id: "123456789012"}
+			functionArn := "arn:" + tt.partition + ":lambda:" + tt.region + ":123456789012:function:hello-abc"
+
+			checks := []struct {
+				builder   string
+				got, want string
+			}{
+				{"LambdaInvocationUri", a.LambdaInvocationUri(functionArn), tt.invocationUri},
+				{"ExecuteApiArn", a.ExecuteApiArn("api123", "*/*/hello-abc"), tt.executeApiArn},
+				{"ExecuteApiUrl", a.ExecuteApiUrl("api123", "default", "hello-abc"), tt.executeApiUrl},
+				{"ManagedPolicyArn", a.ManagedPolicyArn("service-role/AWSLambdaBasicExecutionRole"), tt.managedPolicy},
+				{"RestApiArn", a.RestApiArn("api123"), tt.restApiArn},
+				{"QueueArn", a.QueueArn("jobs"), tt.queueArn},
+				{"QueueArn of an arn", a.QueueArn(tt.queueArn), tt.queueArn},
+				{"BucketArn", a.BucketArn("hello-abc"), tt.bucketArn},
+				{"FunctionArn", a.FunctionArn("hello-abc"), functionArn},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%s: got %s, want %s", c.builder, c.got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// asyncPolicyPrefix start the inline policies of the execution role allowing the lambda to send the results of the
+// asynchronous invocations of a stage, each stage has its own like the triggers policies
+const asyncPolicyPrefix = "awsl-async-"
+
+func asyncPolicyName(stage string) string {
+	return asyncPolicyPrefix + stage
+}
+
+// Async is how the asynchronous invocations of a stage are retried and where their results are sent
+type Async struct {
+	RetryAttempts   int64  `json:"retryAttempts"`
+	MaxEventAge     int64  `json:"maxEventAge"`
+	OnSuccess       string `json:"onSuccess"`
+	OnFailure       string `json:"onFailure"`
+	DeadLetterQueue string `json:"deadLetterQueue"`
+}
+
+// destinationArn return the arn of a destination, the function of an awsl lambda is looked up
+func destinationArn(sess *session.Session, d *manifest.Destination) (string, error) {
+	if d == nil {
+		return "", nil
+	}
+	if d.Arn != "" {
+		return d.Arn, nil
+	}
+	name := fmt.Sprintf("%s-%s", d.Name, d.Id)
+	function := LambdaGet(sess, name)
+	if function == nil {
+		return "", fmt.Errorf("destination lambda %s not found", name)
+	}
+	return *function.Configuration.FunctionArn, nil
+}
+
+// asyncPolicy return the policy document allowing the execution role to send to the destinations and the dead letter
+// queue of the stage of the manifest, empty when there is none
+func asyncPolicy(sess *session.Session, m *manifest.Manifest) (string, error) {
+	if m.Async == nil {
+		return "", nil
+	}
+
+	onSuccess, err := destinationArn(sess, m.Async.OnSuccess)
+	if err != nil {
+		return "", err
+	}
+	onFailure, err := destinationArn(sess, m.Async.OnFailure)
+	if err != nil {
+		return "", err
+	}
+
+	var statements []policyStatement
+	for _, arn := range []string{onSuccess, onFailure, m.Async.DeadLetterQueue} {
+		var action string
+		switch {
+		case arn == "":
+			continue
+		case strings.Contains(arn, ":sqs:"):
+			action = "sqs:SendMessage"
+		case strings.Contains(arn, ":sns:"):
+			action = "sns:Publish"
+		case strings.Contains(arn, ":lambda:"):
+			action = "lambda:InvokeFunction"
+		case strings.Contains(arn, ":events:"):
+			action = "events:PutEvents"
+		}
+		statements = append(statements, policyStatement{Effect: "Allow", Action: []string{action}, Resource: []string{arn}})
+	}
+	return policyDocument(statements)
+}
+
+// AsyncReconcile set the retries and the destinations of the asynchronous invocations of the alias of the stage, the
+// defaults of aws are restored when the manifest has none
+func AsyncReconcile(ctx context.Context, sess *session.Session, name, stage string, m *manifest.Manifest) error {
+	l := lambda.New(sess)
+
+	if m.Async == nil {
+		_, err := l.DeleteFunctionEventInvokeConfig(&lambda.DeleteFunctionEventInvokeConfigInput{
+			FunctionName: aws.String(name),
+			Qualifier:    aws.String(stage),
+		})
+		if errorCode(err) == lambda.ErrCodeResourceNotFoundException {
+			return nil
+		}
+		return err
+	}
+
+	onSuccess, err := destinationArn(sess, m.Async.OnSuccess)
+	if err != nil {
+		return err
+	}
+	onFailure, err := destinationArn(sess, m.Async.OnFailure)
+	if err != nil {
+		return err
+	}
+
+	input := &lambda.PutFunctionEventInvokeConfigInput{
+		DestinationConfig:        &lambda.DestinationConfig{},
+		FunctionName:             aws.String(name),
+		MaximumEventAgeInSeconds: aws.Int64(m.Async.MaxEventAge),
+		MaximumRetryAttempts:     m.Async.RetryAttempts,
+		Qualifier:                aws.String(stage),
+	}
+	if onSuccess != "" {
+		input.DestinationConfig.OnSuccess = &lambda.OnSuccess{Destination: aws.String(onSuccess)}
+	}
+	if onFailure != "" {
+		input.DestinationConfig.OnFailure = &lambda.OnFailure{Destination: aws.String(onFailure)}
+	}
+
+	return util.NewBackoff(ctx, "put event invoke config", func() error {
+		_, err := l.PutFunctionEventInvokeConfig(input)
+		return err
+	}).WithRetryable(permissionsNotReady).WithOnRetry(util.ActionRetry).Execute()
+}
+
+// LambdaDeadLetterPut set the dead letter queue of the manifest on the function, it must be done before a version is
+// published since versions keep the configuration they were published with
+func LambdaDeadLetterPut(ctx context.Context, sess *session.Session, name string, m *manifest.Manifest) error {
+	l := lambda.New(sess)
+
+	cfg, err := l.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	if cfg.DeadLetterConfig != nil {
+		current = aws.StringValue(cfg.DeadLetterConfig.TargetArn)
+	}
+	wanted := ""
+	if m.Async != nil {
+		wanted = m.Async.DeadLetterQueue
+	}
+	if current == wanted {
+		return nil
+	}
+
+	err = util.NewBackoff(ctx, "update dead letter queue", func() error {
+		_, err := l.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+			DeadLetterConfig: &lambda.DeadLetterConfig{TargetArn: aws.String(wanted)},
+			FunctionName:     aws.String(name),
+		})
+		return err
+	}).WithRetryable(permissionsNotReady).WithOnRetry(util.ActionRetry).Execute()
+	if err != nil {
+		return err
+	}
+
+	// The code can't be updated until the configuration is
+	return l.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{FunctionName: aws.String(name)})
+}
+
+// AsyncGet return how the asynchronous invocations of the alias of the stage are handled
+func AsyncGet(sess *session.Session, name, stage string) (*Async, error) {
+	l := lambda.New(sess)
+
+	async := &Async{RetryAttempts: 2, MaxEventAge: 21600}
+
+	output, err := l.GetFunctionEventInvokeConfig(&lambda.GetFunctionEventInvokeConfigInput{
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+	})
+	if err != nil && errorCode(err) != lambda.ErrCodeResourceNotFoundException {
+		return nil, err
+	}
+	if err == nil {
+		if output.MaximumRetryAttempts != nil {
+			async.RetryAttempts = *output.MaximumRetryAttempts
+		}
+		if output.MaximumEventAgeInSeconds != nil {
+			async.MaxEventAge = *output.MaximumEventAgeInSeconds
+		}
+		if c := output.DestinationConfig; c != nil {
+			if c.OnSuccess != nil {
+				async.OnSuccess = aws.StringValue(c.OnSuccess.Destination)
+			}
+			if c.OnFailure != nil {
+				async.OnFailure = aws.StringValue(c.OnFailure.Destination)
+			}
+		}
+	}
+
+	cfg, err := l.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetterConfig != nil {
+		async.DeadLetterQueue = aws.StringValue(cfg.DeadLetterConfig.TargetArn)
+	}
+	return async, nil
+}
@@ -0,0 +1,175 @@
+package amazon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+func authorizerName(name string) string {
+	return fmt.Sprintf("%s-authorizer", name)
+}
+
+// authorizerReconcile create or patch the lambda authorizer protecting the rest api and allow the gateway to invoke
+// it. It returns the id of the authorizer, nil if none is wanted.
+func authorizerReconcile(sess *session.Session, journal *util.Journal, name string, apiId *string, authorizer *manifest.Authorizer) (*string, bool, error) {
+	if authorizer == nil {
+		return nil, false, nil
+	}
+
+	account, err := AccountGet(sess)
+	if err != nil {
+		return nil, false, err
+	}
+
+	functionArn := authorizer.Arn
+	if functionArn == "" {
+		f := LambdaGet(sess, fmt.Sprintf("%s-%s", authorizer.Name, authorizer.Id))
+		if f == nil {
+			return nil, false, fmt.Errorf("authorizer lambda %s with id %s not found", authorizer.Name, authorizer.Id)
+		}
+		functionArn = aws.StringValue(f.Configuration.FunctionArn)
+	}
+	uri := account.LambdaInvocationUri(functionArn)
+
+	gateway := apigateway.New(sess)
+
+	existing, err := authorizerGet(sess, name, apiId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing == nil {
+		output, err := gateway.CreateAuthorizer(&apigateway.CreateAuthorizerInput{
+			RestApiId:                    apiId,
+			Name:                         aws.String(authorizerName(name)),
+			Type:                         aws.String(authorizer.Type),
+			AuthorizerUri:                aws.String(uri),
+			IdentitySource:               aws.String(authorizer.IdentitySource),
+			AuthorizerResultTtlInSeconds: aws.Int64(authorizer.Ttl),
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		if err := authorizerPermit(sess, account, name, apiId, output.Id, functionArn); err != nil {
+			return nil, false, err
+		}
+		journal.Record(fmt.Sprintf("permission of the authorizer %s", authorizerName(name)), func() error {
+			return authorizerRevoke(sess, name, &apigateway.Authorizer{Id: output.Id, AuthorizerUri: aws.String(uri)})
+		})
+		return output.Id, true, nil
+	}
+
+	replace := func(path, value string) *apigateway.PatchOperation {
+		return &apigateway.PatchOperation{Op: aws.String("replace"), Path: aws.String(path), Value: aws.String(value)}
+	}
+
+	var operations []*apigateway.PatchOperation
+	if aws.StringValue(existing.Type) != authorizer.Type {
+		operations = append(operations, replace("/type", authorizer.Type))
+	}
+	if aws.StringValue(existing.IdentitySource) != authorizer.IdentitySource {
+		operations = append(operations, replace("/identitySource", authorizer.IdentitySource))
+	}
+	if aws.Int64Value(existing.AuthorizerResultTtlInSeconds) != authorizer.Ttl {
+		operations = append(operations, replace("/authorizerResultTtlInSeconds", strconv.FormatInt(authorizer.Ttl, 10)))
+	}
+	if aws.StringValue(existing.AuthorizerUri) != uri {
+		if err := authorizerRevoke(sess, name, existing); err != nil {
+			return nil, false, err
+		}
+		if err := authorizerPermit(sess, account, name, apiId, existing.Id, functionArn); err != nil {
+			return nil, false, err
+		}
+		operations = append(operations, replace("/authorizerUri", uri))
+	}
+	if len(operations) == 0 {
+		return existing.Id, false, nil
+	}
+
+	_, err = gateway.UpdateAuthorizer(&apigateway.UpdateAuthorizerInput{
+		RestApiId:       apiId,
+		AuthorizerId:    existing.Id,
+		PatchOperations: operations,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return existing.Id, true, nil
+}
+
+// authorizerPermit allow the rest api to invoke the lambda of the authorizer
+func authorizerPermit(sess *session.Session, account *Account, name string, apiId, authorizerId *string, functionArn string) error {
+	_, err := lambda.New(sess).AddPermission(&lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("apigateway.amazonaws.com"),
+		FunctionName: aws.String(functionArn),
+		SourceArn:    aws.String(account.ExecuteApiArn(*apiId, "authorizers/"+*authorizerId)),
+		StatementId:  aws.String(fmt.Sprintf("%s-%s", authorizerName(name), *authorizerId)),
+	})
+	if errorCode(err) == lambda.ErrCodeResourceConflictException {
+		return nil
+	}
+	return err
+}
+
+// authorizerGet return the authorizer of the rest api created by awsl, nil if there is none
+func authorizerGet(sess *session.Session, name string, apiId *string) (*apigateway.Authorizer, error) {
+	output, err := apigateway.New(sess).GetAuthorizers(&apigateway.GetAuthorizersInput{
+		RestApiId: apiId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range output.Items {
+		if aws.StringValue(a.Name) == authorizerName(name) {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// authorizerDelete delete the authorizer of the rest api, it returns false if there was none
+func authorizerDelete(sess *session.Session, name string, apiId *string) (bool, error) {
+	a, err := authorizerGet(sess, name, apiId)
+	if err != nil || a == nil {
+		return false, err
+	}
+	if err := authorizerRevoke(sess, name, a); err != nil {
+		return false, err
+	}
+	_, err = apigateway.New(sess).DeleteAuthorizer(&apigateway.DeleteAuthorizerInput{
+		RestApiId:    apiId,
+		AuthorizerId: a.Id,
+	})
+	return err == nil, err
+}
+
+// authorizerRevoke remove the permission given by the lambda of the authorizer to the rest api
+func authorizerRevoke(sess *session.Session, name string, a *apigateway.Authorizer) error {
+	// The uri embeds the arn of the function: arn:<partition>:apigateway:<region>:lambda:path/<date>/functions/<arn>/invocations
+	uri := aws.StringValue(a.AuthorizerUri)
+	start := strings.Index(uri, "/functions/")
+	if start == -1 || !strings.HasSuffix(uri, "/invocations") {
+		return errors.New("invalid authorizer uri " + uri)
+	}
+	functionArn := strings.TrimSuffix(uri[start+len("/functions/"):], "/invocations")
+
+	_, err := lambda.New(sess).RemovePermission(&lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionArn),
+		StatementId:  aws.String(fmt.Sprintf("%s-%s", authorizerName(name), *a.Id)),
+	})
+	if errorCode(err) == lambda.ErrCodeResourceNotFoundException {
+		return nil
+	}
+	return err
+}
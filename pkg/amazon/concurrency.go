@@ -0,0 +1,410 @@
+package amazon
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// provisionedDimension is what application auto scaling scale on the alias of a lambda
+const provisionedDimension = applicationautoscaling.ScalableDimensionLambdaFunctionProvisionedConcurrency
+
+func scalingResourceId(name, stage string) string {
+	return fmt.Sprintf("function:%s:%s", name, stage)
+}
+
+// Concurrency is the reserved concurrency of a function, nil when it is not capped, and the provisioned concurrency
+// of its aliases
+type Concurrency struct {
+	Reserved    *int64        `json:"reserved"`
+	Provisioned []Provisioned `json:"provisioned"`
+}
+
+// Provisioned is the provisioned concurrency of the alias of a stage
+type Provisioned struct {
+	Stage     string `json:"stage"`
+	Requested int64  `json:"requested"`
+	Allocated int64  `json:"allocated"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason"`
+}
+
+// provisioningError is returned while the provisioned concurrency of an alias is being allocated
+type provisioningError struct {
+	allocated int64
+	requested int64
+}
+
+func (e provisioningError) Error() string {
+	return fmt.Sprintf("%d of %d instances ready", e.allocated, e.requested)
+}
+
+func provisioning(err error) bool {
+	_, ok := err.(provisioningError)
+	return ok
+}
+
+// ConcurrencyGet return the reserved concurrency of the function and the provisioned concurrency of its aliases
+func ConcurrencyGet(sess *session.Session, name string) (*Concurrency, error) {
+	l := lambda.New(sess)
+
+	reserved, err := l.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{FunctionName: aws.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	c := &Concurrency{Reserved: reserved.ReservedConcurrentExecutions}
+
+	err = l.ListProvisionedConcurrencyConfigsPages(&lambda.ListProvisionedConcurrencyConfigsInput{
+		FunctionName: aws.String(name),
+	}, func(output *lambda.ListProvisionedConcurrencyConfigsOutput, _ bool) bool {
+		for _, p := range output.ProvisionedConcurrencyConfigs {
+			c.Provisioned = append(c.Provisioned, Provisioned{
+				Stage:     functionQualifier(aws.StringValue(p.FunctionArn)),
+				Requested: aws.Int64Value(p.RequestedProvisionedConcurrentExecutions),
+				Allocated: aws.Int64Value(p.AllocatedProvisionedConcurrentExecutions),
+				Status:    aws.StringValue(p.Status),
+				Reason:    aws.StringValue(p.StatusReason),
+			})
+		}
+		return true
+	})
+	return c, err
+}
+
+// ReservedConcurrencyPut cap the concurrent executions of the function, a nil value remove the cap
+func ReservedConcurrencyPut(sess *session.Session, name string, reserved *int64) error {
+	l := lambda.New(sess)
+	if reserved == nil {
+		_, err := l.DeleteFunctionConcurrency(&lambda.DeleteFunctionConcurrencyInput{FunctionName: aws.String(name)})
+		return err
+	}
+	_, err := l.PutFunctionConcurrency(&lambda.PutFunctionConcurrencyInput{
+		FunctionName:                 aws.String(name),
+		ReservedConcurrentExecutions: reserved,
+	})
+	return err
+}
+
+// ProvisionedConcurrencyPut keep n instances of the alias of the stage initialized and wait until they are ready, 0
+// remove the provisioned concurrency
+func ProvisionedConcurrencyPut(ctx context.Context, sess *session.Session, name, stage string, n int64) error {
+	l := lambda.New(sess)
+
+	if n == 0 {
+		_, err := l.DeleteProvisionedConcurrencyConfig(&lambda.DeleteProvisionedConcurrencyConfigInput{
+			FunctionName: aws.String(name),
+			Qualifier:    aws.String(stage),
+		})
+		switch errorCode(err) {
+		case lambda.ErrCodeProvisionedConcurrencyConfigNotFoundException, lambda.ErrCodeResourceNotFoundException:
+			return nil
+		}
+		return err
+	}
+
+	current, err := l.GetProvisionedConcurrencyConfig(&lambda.GetProvisionedConcurrencyConfigInput{
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+	})
+	missing := errorCode(err) == lambda.ErrCodeProvisionedConcurrencyConfigNotFoundException
+	if err != nil && !missing {
+		return err
+	}
+	if missing || aws.Int64Value(current.RequestedProvisionedConcurrentExecutions) != n {
+		_, err := l.PutProvisionedConcurrencyConfig(&lambda.PutProvisionedConcurrencyConfigInput{
+			FunctionName:                    aws.String(name),
+			ProvisionedConcurrentExecutions: aws.Int64(n),
+			Qualifier:                       aws.String(stage),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return provisionedWait(ctx, sess, name, stage)
+}
+
+// provisionedWait wait until the provisioned concurrency of the alias is ready, which takes a few minutes
+func provisionedWait(ctx context.Context, sess *session.Session, name, stage string) error {
+	l := lambda.New(sess)
+
+	return util.NewBackoff(ctx, "provisioned concurrency", func() error {
+		output, err := l.GetProvisionedConcurrencyConfig(&lambda.GetProvisionedConcurrencyConfigInput{
+			FunctionName: aws.String(name),
+			Qualifier:    aws.String(stage),
+		})
+		if err != nil {
+			return err
+		}
+		switch aws.StringValue(output.Status) {
+		case lambda.ProvisionedConcurrencyStatusEnumReady:
+			return nil
+		case lambda.ProvisionedConcurrencyStatusEnumFailed:
+			return fmt.Errorf("provisioned concurrency of stage %s failed: %s", stage, aws.StringValue(output.StatusReason))
+		}
+		return provisioningError{
+			allocated: aws.Int64Value(output.AllocatedProvisionedConcurrentExecutions),
+			requested: aws.Int64Value(output.RequestedProvisionedConcurrentExecutions),
+		}
+	}).WithInterval(5 * time.Second).WithMaxDelay(30 * time.Second).WithMaxAttempt(100).WithBudget(15 * time.Minute).
+		WithRetryable(provisioning).WithOnRetry(util.ActionRetry).Execute()
+}
+
+// ConcurrencyReconcile apply the fields of the concurrency of the manifest and leave the others as they are, so the
+// values set by the concurrency command are kept: the reserved concurrency when given, -1 removing the cap, the
+// provisioned concurrency of the listed aliases, 0 removing it, and the schedules scaling it when given. The scalable
+// targets and scheduled actions created are recorded in the journal.
+func ConcurrencyReconcile(ctx context.Context, sess *session.Session, journal *util.Journal, name string, c *manifest.Concurrency) error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Reserved != nil {
+		reserved := c.Reserved
+		if *reserved < 0 {
+			reserved = nil
+		}
+		if err := ReservedConcurrencyPut(sess, name, reserved); err != nil {
+			return err
+		}
+	}
+
+	if c.Schedules != nil {
+		if err := scalingReconcile(sess, journal, name, c); err != nil {
+			return err
+		}
+	}
+
+	targets, err := scalingTargets(sess, name)
+	if err != nil {
+		return err
+	}
+	scaled := map[string]*applicationautoscaling.ScalableTarget{}
+	for _, t := range targets {
+		scaled[scalingStage(name, aws.StringValue(t.ResourceId))] = t
+	}
+
+	var stages []string
+	for stage := range c.Provisioned {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		n := c.Provisioned[stage]
+		if t, ok := scaled[stage]; ok {
+			initial, err := provisionedInitial(sess, name, stage, t, n)
+			if err != nil {
+				return err
+			}
+			if initial < 0 {
+				continue
+			}
+			n = initial
+		}
+		if err := ProvisionedConcurrencyPut(ctx, sess, name, stage, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// provisionedInitial return the provisioned concurrency to put on an alias registered to application auto scaling,
+// clamped to the range of its target, or -1 when the alias already has one. The scheduled actions own the provisioned
+// concurrency of the alias, a deploy putting the value of the manifest would undo the last one that ran.
+func provisionedInitial(sess *session.Session, name, stage string, target *applicationautoscaling.ScalableTarget, n int64) (int64, error) {
+	_, err := lambda.New(sess).GetProvisionedConcurrencyConfig(&lambda.GetProvisionedConcurrencyConfigInput{
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+	})
+	if err == nil {
+		return -1, nil
+	}
+	if errorCode(err) != lambda.ErrCodeProvisionedConcurrencyConfigNotFoundException {
+		return 0, err
+	}
+	if min := aws.Int64Value(target.MinCapacity); n < min {
+		n = min
+	}
+	if max := aws.Int64Value(target.MaxCapacity); n > max {
+		n = max
+	}
+	return n, nil
+}
+
+// scalingReconcile register the aliases with concurrency schedules to application auto scaling, between the lowest
+// min and the highest max of their schedules, and put their scheduled actions. Aliases without schedules anymore are
+// deregistered with their actions.
+func scalingReconcile(sess *session.Session, journal *util.Journal, name string, c *manifest.Concurrency) error {
+	scaling := applicationautoscaling.New(sess)
+
+	schedules := map[string][]*manifest.ConcurrencySchedule{}
+	for _, s := range c.Schedules {
+		schedules[s.Stage] = append(schedules[s.Stage], s)
+	}
+
+	targets, err := scalingTargets(sess, name)
+	if err != nil {
+		return err
+	}
+	registered := map[string]bool{}
+	for _, t := range targets {
+		stage := scalingStage(name, aws.StringValue(t.ResourceId))
+		if len(schedules[stage]) > 0 {
+			registered[stage] = true
+			continue
+		}
+		if err := scalingDeregister(sess, aws.StringValue(t.ResourceId)); err != nil {
+			return err
+		}
+	}
+
+	for stage, list := range schedules {
+		resourceId := aws.String(scalingResourceId(name, stage))
+
+		min, max := list[0].Min, list[0].Max
+		for _, s := range list[1:] {
+			if s.Min < min {
+				min = s.Min
+			}
+			if s.Max > max {
+				max = s.Max
+			}
+		}
+		_, err := scaling.RegisterScalableTarget(&applicationautoscaling.RegisterScalableTargetInput{
+			MaxCapacity:       aws.Int64(max),
+			MinCapacity:       aws.Int64(min),
+			ResourceId:        resourceId,
+			ScalableDimension: aws.String(provisionedDimension),
+			ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+		})
+		if err != nil {
+			return err
+		}
+		if !registered[stage] {
+			// Deregistering the target deletes its scheduled actions too
+			journal.Record(fmt.Sprintf("scalable target %s", *resourceId), func() error {
+				return scalingDeregister(sess, *resourceId)
+			})
+		}
+
+		wanted := map[string]bool{}
+		for _, s := range list {
+			wanted[s.Name] = true
+		}
+		existing := map[string]bool{}
+		var stale []*string
+		err = scaling.DescribeScheduledActionsPages(&applicationautoscaling.DescribeScheduledActionsInput{
+			ResourceId:        resourceId,
+			ScalableDimension: aws.String(provisionedDimension),
+			ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+		}, func(output *applicationautoscaling.DescribeScheduledActionsOutput, _ bool) bool {
+			for _, a := range output.ScheduledActions {
+				existing[aws.StringValue(a.ScheduledActionName)] = true
+				if !wanted[aws.StringValue(a.ScheduledActionName)] {
+					stale = append(stale, a.ScheduledActionName)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, a := range stale {
+			if err := scheduledActionDelete(sess, *resourceId, *a); err != nil {
+				return err
+			}
+		}
+
+		for _, s := range list {
+			_, err := scaling.PutScheduledAction(&applicationautoscaling.PutScheduledActionInput{
+				ResourceId:        resourceId,
+				ScalableDimension: aws.String(provisionedDimension),
+				ScalableTargetAction: &applicationautoscaling.ScalableTargetAction{
+					MaxCapacity: aws.Int64(s.Max),
+					MinCapacity: aws.Int64(s.Min),
+				},
+				Schedule:            aws.String(s.Schedule),
+				ScheduledActionName: aws.String(s.Name),
+				ServiceNamespace:    aws.String(applicationautoscaling.ServiceNamespaceLambda),
+			})
+			if err != nil {
+				return err
+			}
+			if registered[stage] && !existing[s.Name] {
+				actionName := s.Name
+				journal.Record(fmt.Sprintf("scheduled action %s", actionName), func() error {
+					return scheduledActionDelete(sess, *resourceId, actionName)
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// scalingStage return the stage of a scalable target of the function
+func scalingStage(name, resourceId string) string {
+	return strings.TrimPrefix(resourceId, scalingResourceId(name, ""))
+}
+
+// scalingDeregister deregister the alias from application auto scaling, which deletes its scheduled actions
+func scalingDeregister(sess *session.Session, resourceId string) error {
+	_, err := applicationautoscaling.New(sess).DeregisterScalableTarget(&applicationautoscaling.DeregisterScalableTargetInput{
+		ResourceId:        aws.String(resourceId),
+		ScalableDimension: aws.String(provisionedDimension),
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+	})
+	return err
+}
+
+func scheduledActionDelete(sess *session.Session, resourceId, actionName string) error {
+	_, err := applicationautoscaling.New(sess).DeleteScheduledAction(&applicationautoscaling.DeleteScheduledActionInput{
+		ResourceId:          aws.String(resourceId),
+		ScalableDimension:   aws.String(provisionedDimension),
+		ScheduledActionName: aws.String(actionName),
+		ServiceNamespace:    aws.String(applicationautoscaling.ServiceNamespaceLambda),
+	})
+	return err
+}
+
+// scalingTargets return the aliases of the function registered to application auto scaling
+func scalingTargets(sess *session.Session, name string) ([]*applicationautoscaling.ScalableTarget, error) {
+	prefix := scalingResourceId(name, "")
+
+	var targets []*applicationautoscaling.ScalableTarget
+	err := applicationautoscaling.New(sess).DescribeScalableTargetsPages(&applicationautoscaling.DescribeScalableTargetsInput{
+		ScalableDimension: aws.String(provisionedDimension),
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+	}, func(output *applicationautoscaling.DescribeScalableTargetsOutput, _ bool) bool {
+		for _, t := range output.ScalableTargets {
+			if strings.HasPrefix(aws.StringValue(t.ResourceId), prefix) {
+				targets = append(targets, t)
+			}
+		}
+		return true
+	})
+	return targets, err
+}
+
+// scalingRemove deregister the aliases of the function from application auto scaling, with their scheduled actions
+func scalingRemove(sess *session.Session, name string) []Removal {
+	targets, err := scalingTargets(sess, name)
+	if err != nil {
+		return []Removal{newRemoval(fmt.Sprintf("scalable targets of %s", name), err)}
+	}
+
+	var removals []Removal
+	for _, t := range targets {
+		err := scalingDeregister(sess, aws.StringValue(t.ResourceId))
+		removals = append(removals, newRemoval(fmt.Sprintf("scalable target %s", aws.StringValue(t.ResourceId)), err))
+	}
+	return removals
+}
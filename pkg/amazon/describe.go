@@ -0,0 +1,265 @@
+package amazon
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// describeHistory is the number of deploys shown by a description
+const describeHistory = 10
+
+// Description is everything known about a lambda, gathered from the function, its bucket, its role and its api
+type Description struct {
+	Name          string            `json:"name"`
+	Id            string            `json:"id"`
+	Arn           string            `json:"arn"`
+	Runtime       string            `json:"runtime"`
+	Handler       string            `json:"handler"`
+	Memory        int64             `json:"memory"`
+	Timeout       int64             `json:"timeout"`
+	Architectures []string          `json:"architectures"`
+	Environment   []string          `json:"environment"`
+	CodeSha256    string            `json:"codeSha256"`
+	CodeSize      int64             `json:"codeSize"`
+	Aliases       []Alias           `json:"aliases"`
+	Stages        []Stage           `json:"stages"`
+	FunctionUrls  []string          `json:"functionUrls"`
+	Domains       []Domain          `json:"domains"`
+	Triggers      []Trigger         `json:"triggers"`
+	Role          Role              `json:"role"`
+	Async         *Async            `json:"async"`
+	Concurrency   *Concurrency      `json:"concurrency"`
+	Tags          map[string]string `json:"tags"`
+	History       []Deploy          `json:"history"`
+}
+
+// Alias is a version of the function named after a stage, with the key of its code in the bucket when it is known
+type Alias struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	S3Key   string `json:"s3Key"`
+}
+
+// Role is the execution role of the lambda with its policies
+type Role struct {
+	Name           string   `json:"name"`
+	Arn            string   `json:"arn"`
+	Owner          string   `json:"owner"`
+	Policies       []string `json:"policies"`
+	InlinePolicies []string `json:"inlinePolicies"`
+}
+
+// Deploy is a version of the code stored in the bucket, with the aliases running it
+type Deploy struct {
+	S3Key   string    `json:"s3Key"`
+	Sha256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	Time    time.Time `json:"time"`
+	Aliases []string  `json:"aliases"`
+}
+
+// LambdaDescribe gather the description of the lambda, the asynchronous invocations are the ones of the stage
+func LambdaDescribe(sess *session.Session, name, id, stage string) (*Description, error) {
+	resourceName := fmt.Sprintf("%s-%s", name, id)
+	l := lambda.New(sess)
+
+	function := LambdaGet(sess, resourceName)
+	if function == nil {
+		return nil, fmt.Errorf("lambda %s not found", resourceName)
+	}
+	cfg := function.Configuration
+
+	d := &Description{
+		Name:          name,
+		Id:            id,
+		Arn:           aws.StringValue(cfg.FunctionArn),
+		Runtime:       aws.StringValue(cfg.Runtime),
+		Handler:       aws.StringValue(cfg.Handler),
+		Memory:        aws.Int64Value(cfg.MemorySize),
+		Timeout:       aws.Int64Value(cfg.Timeout),
+		Architectures: aws.StringValueSlice(cfg.Architectures),
+		CodeSha256:    aws.StringValue(cfg.CodeSha256),
+		CodeSize:      aws.Int64Value(cfg.CodeSize),
+		Tags:          aws.StringValueMap(function.Tags),
+	}
+	if cfg.Environment != nil {
+		for k := range cfg.Environment.Variables {
+			d.Environment = append(d.Environment, k)
+		}
+		sort.Strings(d.Environment)
+	}
+
+	// The sha of the code of each alias is used to find its key in the bucket
+	aliasShas := map[string][]string{}
+	err := l.ListAliasesPages(&lambda.ListAliasesInput{
+		FunctionName: aws.String(resourceName),
+	}, func(output *lambda.ListAliasesOutput, _ bool) bool {
+		for _, a := range output.Aliases {
+			d.Aliases = append(d.Aliases, Alias{Name: aws.StringValue(a.Name), Version: aws.StringValue(a.FunctionVersion)})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range d.Aliases {
+		version, err := l.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+			FunctionName: aws.String(resourceName),
+			Qualifier:    aws.String(a.Version),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sha := aws.StringValue(version.CodeSha256)
+		aliasShas[sha] = append(aliasShas[sha], a.Name)
+	}
+
+	d.History, err = deployHistory(sess, resourceName, aliasShas)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range d.Aliases {
+		for _, h := range d.History {
+			for _, name := range h.Aliases {
+				if name == a.Name {
+					d.Aliases[i].S3Key = h.S3Key
+				}
+			}
+		}
+	}
+
+	if d.Stages, err = StageList(sess, resourceName); err != nil {
+		return nil, err
+	}
+	if d.Domains, err = DomainList(sess, resourceName); err != nil {
+		return nil, err
+	}
+	qualifiers, err := functionQualifiers(sess, resourceName)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range qualifiers {
+		input := &lambda.GetFunctionUrlConfigInput{FunctionName: aws.String(resourceName)}
+		if q != "" {
+			input.Qualifier = aws.String(q)
+		}
+		output, err := l.GetFunctionUrlConfig(input)
+		if errorCode(err) == lambda.ErrCodeResourceNotFoundException {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.FunctionUrls = append(d.FunctionUrls, aws.StringValue(output.FunctionUrl))
+	}
+
+	if d.Triggers, err = TriggersList(sess, resourceName); err != nil {
+		return nil, err
+	}
+
+	d.Role, err = roleDescribe(sess, aws.StringValue(cfg.Role), LambdaOwnRole(function))
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Async, err = AsyncGet(sess, resourceName, stage); err != nil && errorCode(err) != lambda.ErrCodeResourceNotFoundException {
+		return nil, err
+	}
+	if d.Concurrency, err = ConcurrencyGet(sess, resourceName); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// deployHistory return the latest deploys of the bucket, newest first. The aliases running a deploy are found by the
+// sha of its zip, which is only known for zips uploaded with it.
+func deployHistory(sess *session.Session, bucket string, aliasShas map[string][]string) ([]Deploy, error) {
+	s := s3.New(sess)
+
+	var objects []*s3.Object
+	err := s.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String(bucket)}, func(output *s3.ListObjectsOutput, _ bool) bool {
+		objects = append(objects, output.Contents...)
+		return true
+	})
+	if errorCode(err) == s3.ErrCodeNoSuchBucket {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Deploy
+	for _, o := range objects {
+		if !versionKeyRegexp.MatchString(aws.StringValue(o.Key)) {
+			continue
+		}
+		split := strings.SplitN(strings.TrimSuffix(aws.StringValue(o.Key), ".zip"), "-", 2)
+		t, err := strconv.ParseInt(split[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		history = append(history, Deploy{S3Key: *o.Key, Sha256: split[1], Size: aws.Int64Value(o.Size), Time: time.Unix(t, 0)})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Time.After(history[j].Time)
+	})
+
+	// Older deploys are only looked at while an alias is not found
+	remaining := len(aliasShas)
+	for i := range history {
+		if i >= describeHistory && remaining == 0 {
+			break
+		}
+		head, err := s.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(history[i].S3Key)})
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range head.Metadata {
+			if strings.EqualFold(k, codeShaMetadata) {
+				if aliases, ok := aliasShas[aws.StringValue(v)]; ok {
+					history[i].Aliases = aliases
+					delete(aliasShas, aws.StringValue(v))
+					remaining--
+				}
+			}
+		}
+	}
+
+	var list []Deploy
+	for i, h := range history {
+		if i < describeHistory || len(h.Aliases) > 0 {
+			list = append(list, h)
+		}
+	}
+	return list, nil
+}
+
+// roleDescribe return the execution role with its policies, the policies of a role in another account can't be read
+func roleDescribe(sess *session.Session, arn string, own bool) (Role, error) {
+	role := Role{Name: arn[strings.LastIndex(arn, "/")+1:], Arn: arn, Owner: roleOwnerExternal}
+	if own {
+		role.Owner = roleOwnerAwsl
+	}
+
+	account, err := AccountGet(sess)
+	if err != nil {
+		return role, err
+	}
+	if !strings.Contains(arn, ":"+account.Id+":") {
+		return role, nil
+	}
+
+	if role.Policies, err = roleAttachedPolicies(sess, role.Name); err != nil {
+		return role, err
+	}
+	role.InlinePolicies, err = roleInlinePolicies(sess, role.Name)
+	return role, err
+}
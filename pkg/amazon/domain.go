@@ -0,0 +1,170 @@
+package amazon
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+)
+
+type Domain struct {
+	Name     string `json:"name"`
+	BasePath string `json:"basePath"`
+	Stage    string `json:"stage"`
+	Target   string `json:"target"`
+}
+
+// DomainCreate map a custom domain to a stage of the rest api of the lambda, the domain is created if it does not exist yet.
+// A created domain is tagged as managed by awsl, it is the only kind of domain awsl ever deletes. It returns the dns
+// target the domain must point to.
+func DomainCreate(sess *session.Session, name, domain, certificateArn, basePath, stage string) (string, error) {
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return "", err
+	}
+	if api == nil {
+		return "", errors.New("no api gateway found for this lambda")
+	}
+
+	gateway := apigateway.New(sess)
+
+	d, err := gateway.GetDomainName(&apigateway.GetDomainNameInput{
+		DomainName: aws.String(domain),
+	})
+	if err != nil {
+		if certificateArn == "" {
+			return "", errors.New("a certificate is required to create the domain " + domain)
+		}
+		d, err = gateway.CreateDomainName(&apigateway.CreateDomainNameInput{
+			DomainName:             aws.String(domain),
+			RegionalCertificateArn: aws.String(certificateArn),
+			EndpointConfiguration: &apigateway.EndpointConfiguration{
+				Types: []*string{aws.String("REGIONAL")},
+			},
+			Tags: map[string]*string{tagManager: aws.String(managerAwsl)},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	input := &apigateway.CreateBasePathMappingInput{
+		DomainName: aws.String(domain),
+		RestApiId:  api.Id,
+		Stage:      aws.String(stage),
+	}
+	if basePath != "" {
+		input.BasePath = aws.String(basePath)
+	}
+	if _, err := gateway.CreateBasePathMapping(input); err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(d.RegionalDomainName), nil
+}
+
+// DomainList return every custom domain mapped to the rest api of the lambda
+func DomainList(sess *session.Session, name string) ([]Domain, error) {
+	api, err := GatewayGet(sess, name)
+	if err != nil || api == nil {
+		return nil, err
+	}
+	return domainListByApi(sess, aws.StringValue(api.Id))
+}
+
+// DomainDelete remove the mappings between the domain and the rest api of the lambda, the domain itself is
+// deleted when awsl created it and no other mapping remain.
+func DomainDelete(sess *session.Session, name, domain string) error {
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return err
+	}
+	if api == nil {
+		return errors.New("no api gateway found for this lambda")
+	}
+	return domainUnmap(sess, aws.StringValue(api.Id), domain)
+}
+
+func domainListByApi(sess *session.Session, apiId string) ([]Domain, error) {
+	gateway := apigateway.New(sess)
+
+	var domainNames []*apigateway.DomainName
+	err := gateway.GetDomainNamesPages(&apigateway.GetDomainNamesInput{}, func(output *apigateway.GetDomainNamesOutput, _ bool) bool {
+		domainNames = append(domainNames, output.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Domain
+	for _, d := range domainNames {
+		err := gateway.GetBasePathMappingsPages(&apigateway.GetBasePathMappingsInput{
+			DomainName: d.DomainName,
+		}, func(output *apigateway.GetBasePathMappingsOutput, _ bool) bool {
+			for _, m := range output.Items {
+				if aws.StringValue(m.RestApiId) == apiId {
+					list = append(list, Domain{
+						Name:     aws.StringValue(d.DomainName),
+						BasePath: aws.StringValue(m.BasePath),
+						Stage:    aws.StringValue(m.Stage),
+						Target:   aws.StringValue(d.RegionalDomainName),
+					})
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// domainUnmap remove the mappings between the domain and the rest api, a domain created by awsl is deleted once no
+// mapping remain while the others are left as they are with their certificate
+func domainUnmap(sess *session.Session, apiId, domain string) error {
+	gateway := apigateway.New(sess)
+
+	var mappings []*apigateway.BasePathMapping
+	err := gateway.GetBasePathMappingsPages(&apigateway.GetBasePathMappingsInput{
+		DomainName: aws.String(domain),
+	}, func(output *apigateway.GetBasePathMappingsOutput, _ bool) bool {
+		mappings = append(mappings, output.Items...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	remaining := 0
+	for _, m := range mappings {
+		if aws.StringValue(m.RestApiId) != apiId {
+			remaining++
+			continue
+		}
+		_, err := gateway.DeleteBasePathMapping(&apigateway.DeleteBasePathMappingInput{
+			DomainName: aws.String(domain),
+			BasePath:   m.BasePath,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+	d, err := gateway.GetDomainName(&apigateway.GetDomainNameInput{DomainName: aws.String(domain)})
+	if err != nil {
+		return err
+	}
+	if !managedByAwsl(d.Tags) {
+		return nil
+	}
+	_, err = gateway.DeleteDomainName(&apigateway.DeleteDomainNameInput{
+		DomainName: aws.String(domain),
+	})
+	return err
+}
@@ -0,0 +1,32 @@
+package amazon
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// errorCode return the code of an aws error, an empty string for any other error
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+// roleNotReady tell if lambda refused a role because it is not yet propagated by iam, such errors disappear after a
+// few seconds
+func roleNotReady(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == lambda.ErrCodeInvalidParameterValueException &&
+		strings.Contains(aerr.Message(), "cannot be assumed")
+}
+
+// permissionsNotReady tell if lambda refused an event source because the policy allowing the role to read it is not
+// yet propagated by iam
+func permissionsNotReady(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == lambda.ErrCodeInvalidParameterValueException &&
+		strings.Contains(aerr.Message(), "execution role does not have permissions")
+}
@@ -0,0 +1,513 @@
+package amazon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+)
+
+// GatewayStage is the stage on which the rest api of a lambda is deployed when none is given
+const GatewayStage = "default"
+
+func gatewayName(name string) string {
+	return fmt.Sprintf("%s-API", name)
+}
+
+func gatewayAuthorizationType(auth string) string {
+	if auth == manifest.AuthIam {
+		return "AWS_IAM"
+	}
+	return "NONE"
+}
+
+// GatewayGet return the rest api of the lambda, nil if there is none
+func GatewayGet(sess *session.Session, name string) (*apigateway.RestApi, error) {
+	var api *apigateway.RestApi
+	err := apigateway.New(sess).GetRestApisPages(&apigateway.GetRestApisInput{}, func(output *apigateway.GetRestApisOutput, _ bool) bool {
+		for _, i := range output.Items {
+			if aws.StringValue(i.Name) == gatewayName(name) {
+				api = i
+				return false
+			}
+		}
+		return true
+	})
+	return api, err
+}
+
+// GatewayIndex return every rest api of the region by name, so the apis of many lambdas are found with a single
+// listing
+func GatewayIndex(sess *session.Session) (map[string]*apigateway.RestApi, error) {
+	index := map[string]*apigateway.RestApi{}
+	err := apigateway.New(sess).GetRestApisPages(&apigateway.GetRestApisInput{}, func(output *apigateway.GetRestApisOutput, _ bool) bool {
+		for _, i := range output.Items {
+			index[aws.StringValue(i.Name)] = i
+		}
+		return true
+	})
+	return index, err
+}
+
+// GatewayIndexGet return the rest api of the lambda from the index, nil if there is none
+func GatewayIndexGet(index map[string]*apigateway.RestApi, name string) *apigateway.RestApi {
+	return index[gatewayName(name)]
+}
+
+// GatewayReconcile compare the rest api of the lambda to the one described by the manifest and create or patch only
+// the missing or different pieces. It returns true when something changed and the stage must be deployed again.
+// Created resources that are not removed with the rest api are recorded in the journal.
+func GatewayReconcile(sess *session.Session, journal *util.Journal, name, functionArn string, m *manifest.Manifest) (bool, error) {
+	gateway := apigateway.New(sess)
+	changed := false
+	functionArn = unqualifiedFunctionArn(functionArn)
+
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return false, err
+	}
+	if api == nil {
+		api, err = gateway.CreateRestApi(&apigateway.CreateRestApiInput{
+			ApiKeySource:     aws.String("HEADER"),
+			BinaryMediaTypes: []*string{aws.String("*/*")},
+			EndpointConfiguration: &apigateway.EndpointConfiguration{
+				Types: []*string{aws.String("REGIONAL")},
+			},
+			Name: aws.String(gatewayName(name)),
+			Tags: map[string]*string{tagManager: aws.String(managerAwsl)},
+		})
+		if err != nil {
+			return false, err
+		}
+		journal.Record(fmt.Sprintf("rest api %s", gatewayName(name)), func() error {
+			_, err := gateway.DeleteRestApi(&apigateway.DeleteRestApiInput{RestApiId: api.Id})
+			return err
+		})
+		changed = true
+	}
+
+	resourceId, resourceChanged, err := gatewayResourceReconcile(sess, api.Id, name)
+	if err != nil {
+		return false, err
+	}
+
+	authorizerId, authorizerChanged, err := authorizerReconcile(sess, journal, name, api.Id, m.Authorizer)
+	if err != nil {
+		return false, err
+	}
+
+	methodChanged, err := gatewayMethodReconcile(sess, api.Id, resourceId, authorizerId, functionArn, m)
+	if err != nil {
+		return false, err
+	}
+
+	// An authorizer no longer wanted can only be deleted once the method stopped using it
+	if m.Authorizer == nil {
+		deleted, err := authorizerDelete(sess, name, api.Id)
+		if err != nil {
+			return false, err
+		}
+		authorizerChanged = authorizerChanged || deleted
+	}
+
+	corsChanged, err := gatewayCorsReconcile(sess, api.Id, resourceId, m.Cors)
+	if err != nil {
+		return false, err
+	}
+
+	return changed || resourceChanged || authorizerChanged || methodChanged || corsChanged, nil
+}
+
+// gatewayResourceReconcile return the id of the resource of the lambda, creating it if it is missing
+func gatewayResourceReconcile(sess *session.Session, apiId *string, name string) (*string, bool, error) {
+	gateway := apigateway.New(sess)
+
+	var parentId, resourceId *string
+	err := gateway.GetResourcesPages(&apigateway.GetResourcesInput{
+		RestApiId: apiId,
+	}, func(output *apigateway.GetResourcesOutput, _ bool) bool {
+		for _, r := range output.Items {
+			switch aws.StringValue(r.Path) {
+			case "/":
+				parentId = r.Id
+			case "/" + name:
+				resourceId = r.Id
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if resourceId != nil {
+		return resourceId, false, nil
+	}
+	if parentId == nil {
+		return nil, false, errors.New("bad api gateway construction")
+	}
+
+	resource, err := gateway.CreateResource(&apigateway.CreateResourceInput{
+		ParentId:  parentId,
+		PathPart:  aws.String(name),
+		RestApiId: apiId,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return resource.Id, true, nil
+}
+
+// gatewayMethodReconcile make the ANY method of the resource invoke the alias of the stage through a proxy integration
+func gatewayMethodReconcile(sess *session.Session, apiId, resourceId, authorizerId *string, functionArn string, m *manifest.Manifest) (bool, error) {
+	gateway := apigateway.New(sess)
+	changed := false
+
+	authorizationType := gatewayAuthorizationType(m.Auth)
+	if authorizerId != nil {
+		authorizationType = "CUSTOM"
+	}
+	apiKeyRequired := m.Auth == manifest.AuthApiKey
+
+	method, err := gateway.GetMethod(&apigateway.GetMethodInput{
+		HttpMethod: aws.String("ANY"),
+		ResourceId: resourceId,
+		RestApiId:  apiId,
+	})
+	// Without an auth in the flags or the manifest the method keeps the one given on a previous deploy, the api is
+	// only made public by an explicit none
+	if err == nil && m.Auth == "" {
+		if t := aws.StringValue(method.AuthorizationType); authorizerId == nil && t != "CUSTOM" {
+			authorizationType = t
+		}
+		apiKeyRequired = aws.BoolValue(method.ApiKeyRequired)
+	}
+	if errorCode(err) == apigateway.ErrCodeNotFoundException {
+		method, err = gateway.PutMethod(&apigateway.PutMethodInput{
+			ApiKeyRequired:    aws.Bool(apiKeyRequired),
+			AuthorizationType: aws.String(authorizationType),
+			AuthorizerId:      authorizerId,
+			HttpMethod:        aws.String("ANY"),
+			ResourceId:        resourceId,
+			RestApiId:         apiId,
+		})
+		changed = true
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var operations []*apigateway.PatchOperation
+	if aws.StringValue(method.AuthorizationType) != authorizationType {
+		operations = append(operations, &apigateway.PatchOperation{
+			Op: aws.String("replace"), Path: aws.String("/authorizationType"), Value: aws.String(authorizationType),
+		})
+	}
+	if aws.StringValue(method.AuthorizerId) != aws.StringValue(authorizerId) {
+		operations = append(operations, &apigateway.PatchOperation{
+			Op: aws.String("replace"), Path: aws.String("/authorizerId"), Value: authorizerId,
+		})
+	}
+	if aws.BoolValue(method.ApiKeyRequired) != apiKeyRequired {
+		operations = append(operations, &apigateway.PatchOperation{
+			Op: aws.String("replace"), Path: aws.String("/apiKeyRequired"), Value: aws.String(strconv.FormatBool(apiKeyRequired)),
+		})
+	}
+	if len(operations) > 0 {
+		_, err := gateway.UpdateMethod(&apigateway.UpdateMethodInput{
+			HttpMethod:      aws.String("ANY"),
+			ResourceId:      resourceId,
+			RestApiId:       apiId,
+			PatchOperations: operations,
+		})
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	account, err := AccountGet(sess)
+	if err != nil {
+		return false, err
+	}
+
+	uri := account.LambdaInvocationUri(fmt.Sprintf("%s:${stageVariables.%s}", functionArn, stageVariableAlias))
+	integration := method.MethodIntegration
+	if integration == nil || aws.StringValue(integration.Type) != "AWS_PROXY" || aws.StringValue(integration.Uri) != uri {
+		_, err := gateway.PutIntegration(&apigateway.PutIntegrationInput{
+			HttpMethod:            aws.String("ANY"),
+			IntegrationHttpMethod: aws.String("POST"),
+			PassthroughBehavior:   aws.String("WHEN_NO_MATCH"),
+			ResourceId:            resourceId,
+			RestApiId:             apiId,
+			TimeoutInMillis:       aws.Int64(29000),
+			Type:                  aws.String("AWS_PROXY"),
+			Uri:                   aws.String(uri),
+		})
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if integration == nil || integration.IntegrationResponses["200"] == nil {
+		_, err := gateway.PutIntegrationResponse(&apigateway.PutIntegrationResponseInput{
+			HttpMethod:       aws.String("ANY"),
+			ResourceId:       resourceId,
+			RestApiId:        apiId,
+			SelectionPattern: aws.String(".*"),
+			StatusCode:       aws.String("200"),
+		})
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if method.MethodResponses["200"] == nil {
+		_, err := gateway.PutMethodResponse(&apigateway.PutMethodResponseInput{
+			HttpMethod: aws.String("ANY"),
+			ResourceId: resourceId,
+			RestApiId:  apiId,
+			StatusCode: aws.String("200"),
+		})
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// gatewayCorsReconcile put the preflight method of the resource when the cors configuration changed, or remove it
+// when cors is no longer wanted
+func gatewayCorsReconcile(sess *session.Session, apiId, resourceId *string, cors *manifest.Cors) (bool, error) {
+	gateway := apigateway.New(sess)
+
+	method, err := gateway.GetMethod(&apigateway.GetMethodInput{
+		HttpMethod: aws.String("OPTIONS"),
+		ResourceId: resourceId,
+		RestApiId:  apiId,
+	})
+	if err != nil && errorCode(err) != apigateway.ErrCodeNotFoundException {
+		return false, err
+	}
+
+	if cors == nil {
+		if method == nil {
+			return false, nil
+		}
+		// The gateway responses carrying the origin of the preflight are the ones put by awsl, the others are kept
+		var origin *string
+		if method.MethodIntegration != nil && method.MethodIntegration.IntegrationResponses["200"] != nil {
+			origin = method.MethodIntegration.IntegrationResponses["200"].ResponseParameters["method.response.header.Access-Control-Allow-Origin"]
+		}
+		_, err := gateway.DeleteMethod(&apigateway.DeleteMethodInput{
+			HttpMethod: aws.String("OPTIONS"),
+			ResourceId: resourceId,
+			RestApiId:  apiId,
+		})
+		if err != nil {
+			return false, err
+		}
+		if origin == nil {
+			return true, nil
+		}
+		for _, responseType := range []string{"DEFAULT_4XX", "DEFAULT_5XX"} {
+			response, err := gateway.GetGatewayResponse(&apigateway.GetGatewayResponseInput{
+				RestApiId:    apiId,
+				ResponseType: aws.String(responseType),
+			})
+			if errorCode(err) == apigateway.ErrCodeNotFoundException {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			if !equalStringMaps(response.ResponseParameters, corsGatewayResponseParameters(origin)) {
+				continue
+			}
+			_, err = gateway.DeleteGatewayResponse(&apigateway.DeleteGatewayResponseInput{
+				RestApiId:    apiId,
+				ResponseType: aws.String(responseType),
+			})
+			if err != nil && errorCode(err) != apigateway.ErrCodeNotFoundException {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	_, parameters, templates := corsParameters(cors)
+	if method != nil && method.MethodIntegration != nil {
+		if response := method.MethodIntegration.IntegrationResponses["200"]; response != nil &&
+			equalStringMaps(response.ResponseParameters, parameters) && equalStringMaps(response.ResponseTemplates, templates) {
+			return false, nil
+		}
+	}
+
+	return true, gatewayCorsPut(sess, apiId, resourceId, cors)
+}
+
+// gatewayCorsPut answer preflight requests of the resource with a mock integration and add the cors headers to the
+// errors returned by the gateway itself, responses of the lambda must still carry their own headers.
+func gatewayCorsPut(sess *session.Session, apiId, resourceId *string, cors *manifest.Cors) error {
+	gateway := apigateway.New(sess)
+
+	methodParameters, integrationParameters, templates := corsParameters(cors)
+
+	// An existing preflight method is replaced so the configuration always match the one asked
+	_, _ = gateway.DeleteMethod(&apigateway.DeleteMethodInput{
+		HttpMethod: aws.String("OPTIONS"),
+		ResourceId: resourceId,
+		RestApiId:  apiId,
+	})
+
+	_, err := gateway.PutMethod(&apigateway.PutMethodInput{
+		ApiKeyRequired:    aws.Bool(false),
+		AuthorizationType: aws.String("NONE"),
+		HttpMethod:        aws.String("OPTIONS"),
+		ResourceId:        resourceId,
+		RestApiId:         apiId,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = gateway.PutIntegration(&apigateway.PutIntegrationInput{
+		HttpMethod:          aws.String("OPTIONS"),
+		ResourceId:          resourceId,
+		RestApiId:           apiId,
+		Type:                aws.String("MOCK"),
+		PassthroughBehavior: aws.String("WHEN_NO_MATCH"),
+		RequestTemplates: map[string]*string{
+			"application/json": aws.String(`{"statusCode": 200}`),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = gateway.PutMethodResponse(&apigateway.PutMethodResponseInput{
+		HttpMethod:         aws.String("OPTIONS"),
+		ResourceId:         resourceId,
+		RestApiId:          apiId,
+		StatusCode:         aws.String("200"),
+		ResponseParameters: methodParameters,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = gateway.PutIntegrationResponse(&apigateway.PutIntegrationResponseInput{
+		HttpMethod:         aws.String("OPTIONS"),
+		ResourceId:         resourceId,
+		RestApiId:          apiId,
+		StatusCode:         aws.String("200"),
+		ResponseParameters: integrationParameters,
+		ResponseTemplates:  templates,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, responseType := range []string{"DEFAULT_4XX", "DEFAULT_5XX"} {
+		_, err := gateway.PutGatewayResponse(&apigateway.PutGatewayResponseInput{
+			RestApiId:          apiId,
+			ResponseType:       aws.String(responseType),
+			ResponseParameters: corsGatewayResponseParameters(integrationParameters["method.response.header.Access-Control-Allow-Origin"]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// corsGatewayResponseParameters return the parameters of the gateway responses adding the origin header to the errors
+// returned by the gateway
+func corsGatewayResponseParameters(origin *string) map[string]*string {
+	return map[string]*string{"gatewayresponse.header.Access-Control-Allow-Origin": origin}
+}
+
+// corsParameters return the parameters of the method response, of the integration response and the templates of the
+// integration response of the preflight method
+func corsParameters(cors *manifest.Cors) (map[string]*bool, map[string]*string, map[string]*string) {
+	origins := cors.Origins
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	methods := cors.Methods
+	if len(methods) == 0 {
+		methods = []string{"*"}
+	}
+	headers := cors.Headers
+	if len(headers) == 0 {
+		headers = []string{"Content-Type", "Authorization", "X-Api-Key", "X-Amz-Date", "X-Amz-Security-Token"}
+	}
+
+	values := map[string]string{
+		"Access-Control-Allow-Origin":  origins[0],
+		"Access-Control-Allow-Methods": strings.Join(methods, ","),
+		"Access-Control-Allow-Headers": strings.Join(headers, ","),
+	}
+	if cors.MaxAge > 0 {
+		values["Access-Control-Max-Age"] = strconv.FormatInt(cors.MaxAge, 10)
+	}
+	if cors.Credentials {
+		values["Access-Control-Allow-Credentials"] = "true"
+	}
+	if len(origins) > 1 {
+		values["Vary"] = "Origin"
+	}
+
+	methodParameters := map[string]*bool{}
+	integrationParameters := map[string]*string{}
+	for header, value := range values {
+		methodParameters["method.response.header."+header] = aws.Bool(false)
+		integrationParameters["method.response.header."+header] = aws.String(fmt.Sprintf("'%s'", value))
+	}
+
+	var templates map[string]*string
+	if len(origins) > 1 {
+		//
+		// Only one origin can be returned, the one of the request is echoed back when it is allowed
+		//
+		templates = map[string]*string{
+			"application/json": aws.String(corsOriginTemplate(origins)),
+		}
+	}
+	return methodParameters, integrationParameters, templates
+}
+
+func corsOriginTemplate(origins []string) string {
+	quoted := make([]string, len(origins))
+	for i, o := range origins {
+		quoted[i] = strconv.Quote(o)
+	}
+	return fmt.Sprintf(`#set($origin = $input.params().header.get("Origin"))
+#foreach($allowed in [%s])
+#if($allowed == $origin)
+#set($context.responseOverride.header.Access-Control-Allow-Origin = $origin)
+#end
+#end`, strings.Join(quoted, ","))
+}
+
+func equalStringMaps(a, b map[string]*string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || aws.StringValue(v) != aws.StringValue(w) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package amazon
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Kind of resources left behind by awsl
+const (
+	OrphanRestApi = "rest api"
+	OrphanBucket  = "bucket"
+	OrphanRole    = "role"
+)
+
+// versionKeyRegexp match the keys of the versions stored in the bucket of a lambda: <unix time>-<sha256>.zip
+var versionKeyRegexp = regexp.MustCompile(`^[0-9]+-[a-f0-9]{64}\.zip$`)
+
+// Orphan is a resource created by awsl whose lambda does not exist anymore
+type Orphan struct {
+	Kind     string
+	Resource string
+	// Name is the name of the lambda the resource belonged to
+	Name string
+}
+
+// OrphanList return the rest apis, buckets and roles tagged as managed by awsl whose lambda, found by the naming
+// conventions, is missing from the region of the session. Resources without the tag are never offered for deletion.
+func OrphanList(sess *session.Session) ([]Orphan, error) {
+	functions, err := LambdaGetAll(sess, true)
+	if err != nil {
+		return nil, err
+	}
+	exist := map[string]bool{}
+	for _, f := range functions {
+		exist[aws.StringValue(f.FunctionName)] = true
+	}
+
+	var orphans []Orphan
+
+	err = apigateway.New(sess).GetRestApisPages(&apigateway.GetRestApisInput{}, func(output *apigateway.GetRestApisOutput, _ bool) bool {
+		for _, api := range output.Items {
+			name := strings.TrimSuffix(aws.StringValue(api.Name), "-API")
+			if name != aws.StringValue(api.Name) && managedByAwsl(api.Tags) && !exist[name] {
+				orphans = append(orphans, Orphan{Kind: OrphanRestApi, Resource: fmt.Sprintf("%s (%s)", *api.Name, *api.Id), Name: name})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := bucketOrphans(sess, exist)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, buckets...)
+
+	i := iam.New(sess)
+	var roles []*iam.Role
+	err = i.ListRolesPages(&iam.ListRolesInput{
+		PathPrefix: aws.String("/service-role/"),
+	}, func(output *iam.ListRolesOutput, _ bool) bool {
+		for _, role := range output.Roles {
+			if !exist[aws.StringValue(role.RoleName)] {
+				roles = append(roles, role)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		name := aws.StringValue(role.RoleName)
+		document, err := url.QueryUnescape(aws.StringValue(role.AssumeRolePolicyDocument))
+		if err != nil {
+			continue
+		}
+		if ok, _ := trustLambda(document); !ok {
+			continue
+		}
+		// Roles are global while the lambdas are listed in the region of the session, a role is only an orphan of the
+		// region recorded at its creation and roles that can't be placed are skipped
+		tags, err := roleTags(sess, name)
+		if err != nil {
+			return nil, err
+		}
+		if tags[tagManager] == managerAwsl && tags[tagRegion] == aws.StringValue(sess.Config.Region) {
+			orphans = append(orphans, Orphan{Kind: OrphanRole, Resource: name, Name: name})
+		}
+	}
+
+	return orphans, nil
+}
+
+// bucketOrphans return the buckets of the region of the session tagged as managed by awsl whose lambda is missing
+func bucketOrphans(sess *session.Session, exist map[string]bool) ([]Orphan, error) {
+	s := s3.New(sess)
+
+	output, err := s.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Orphan
+	for _, b := range output.Buckets {
+		name := aws.StringValue(b.Name)
+		if exist[name] {
+			continue
+		}
+
+		location, err := s.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: b.Name})
+		if err != nil {
+			continue
+		}
+		// Buckets of us-east-1 have no location constraint
+		region := aws.StringValue(location.LocationConstraint)
+		if region == "" {
+			region = "us-east-1"
+		}
+		if region != aws.StringValue(sess.Config.Region) {
+			continue
+		}
+
+		tagging, err := s.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: b.Name})
+		if err != nil {
+			continue
+		}
+		for _, t := range tagging.TagSet {
+			if aws.StringValue(t.Key) == tagManager && aws.StringValue(t.Value) == managerAwsl {
+				orphans = append(orphans, Orphan{Kind: OrphanBucket, Resource: name, Name: name})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// managedByAwsl tell if the tags mark the resource as created by awsl
+func managedByAwsl(tags map[string]*string) bool {
+	return aws.StringValue(tags[tagManager]) == managerAwsl
+}
+
+// OrphanRemove delete the orphan with everything attached to it
+func OrphanRemove(sess *session.Session, o Orphan) []Removal {
+	switch o.Kind {
+	case OrphanRestApi:
+		return gatewayRemove(sess, o.Name)
+	case OrphanRole:
+		return roleRemove(sess, o.Name)
+	case OrphanBucket:
+		return []Removal{newRemoval(fmt.Sprintf("bucket %s", o.Name), S3DeleteBucket(sess, o.Name))}
+	}
+	return []Removal{{Resource: o.Resource, Status: RemovalFailed, Err: fmt.Errorf("unknown kind %s", o.Kind)}}
+}
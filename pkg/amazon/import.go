@@ -0,0 +1,183 @@
+package amazon
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// bucketNameRegexp is what s3 accepts as a bucket name, the bucket of a lambda is named after its function
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+// ImportId return the id of a function named <name>-<id> once imported, when no name is given the function name is
+// split on its last dash like the list command does. Functions not named this way or whose name is not a valid bucket
+// name are refused, every resource of a lambda is named after its function.
+func ImportId(functionName, name string) (string, string, error) {
+	if !bucketNameRegexp.MatchString(functionName) {
+		return "", "", fmt.Errorf("function %s can't be imported, the bucket storing its code is named after it and only lowercase letters, digits and dashes are allowed", functionName)
+	}
+	if name == "" {
+		i := strings.LastIndex(functionName, "-")
+		if i <= 0 {
+			return "", "", fmt.Errorf("function %s can't be imported, it is not named <name>-<id>", functionName)
+		}
+		name = functionName[:i]
+	}
+	if !strings.HasPrefix(functionName, name+"-") || len(functionName) == len(name)+1 {
+		return "", "", fmt.Errorf("function %s is not named %s-<id>", functionName, name)
+	}
+	return name, functionName[len(name)+1:], nil
+}
+
+// LambdaImportGet return the function to import, it must be packaged as a zip and not already managed by awsl
+func LambdaImportGet(sess *session.Session, functionName string) (*lambda.GetFunctionOutput, error) {
+	output, err := lambda.New(sess).GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(functionName)})
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(output.Tags[tagManager]) == managerAwsl {
+		return nil, fmt.Errorf("function %s is already managed by awsl", functionName)
+	}
+	if aws.StringValue(output.Code.RepositoryType) != "S3" {
+		return nil, fmt.Errorf("function %s is not packaged as a zip", functionName)
+	}
+	return output, nil
+}
+
+// LambdaCodeDownload download the code package of the function, it returns its sha256 and the local zip
+func LambdaCodeDownload(function *lambda.GetFunctionOutput) (string, *os.File, error) {
+	response, err := http.Get(aws.StringValue(function.Code.Location))
+	if err != nil {
+		return "", nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("downloading the code of the function failed: %s", response.Status)
+	}
+
+	file, err := ioutil.TempFile("", "awsl-import-*.zip")
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, h), response.Body); err != nil {
+		file.Close()
+		return "", nil, err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), file, file.Close()
+}
+
+// GatewayAdopt look for the rest api integrating the function and rename it after the lambda so awsl find it, nil is
+// returned when there is none
+func GatewayAdopt(sess *session.Session, name, functionArn string) (*apigateway.RestApi, error) {
+	gateway := apigateway.New(sess)
+	functionArn = unqualifiedFunctionArn(functionArn)
+
+	var apis []*apigateway.RestApi
+	err := gateway.GetRestApisPages(&apigateway.GetRestApisInput{}, func(output *apigateway.GetRestApisOutput, _ bool) bool {
+		apis = append(apis, output.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*apigateway.RestApi
+	for _, api := range apis {
+		integrated, shared, err := gatewayIntegrates(sess, api.Id, functionArn)
+		if err != nil {
+			return nil, err
+		}
+		if !integrated {
+			continue
+		}
+		// The rest api is deleted with the lambda, it would take the routes of the other backends along
+		if shared {
+			return nil, fmt.Errorf("rest api %s also integrates other backends, it can't be managed by awsl", aws.StringValue(api.Name))
+		}
+		found = append(found, api)
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		var names []string
+		for _, api := range found {
+			names = append(names, aws.StringValue(api.Name))
+		}
+		return nil, fmt.Errorf("several rest apis integrate the function: %s", strings.Join(names, ", "))
+	}
+
+	api := found[0]
+	if aws.StringValue(api.Name) == gatewayName(name) {
+		return api, nil
+	}
+	existing, err := GatewayGet(sess, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errors.New("a rest api named " + gatewayName(name) + " already exists")
+	}
+	return gateway.UpdateRestApi(&apigateway.UpdateRestApiInput{
+		RestApiId: api.Id,
+		PatchOperations: []*apigateway.PatchOperation{{
+			Op:    aws.String("replace"),
+			Path:  aws.String("/name"),
+			Value: aws.String(gatewayName(name)),
+		}},
+	})
+}
+
+// gatewayIntegrates tell if a method of the rest api invokes the function, whatever its qualifier, and if another
+// method integrates something else than the function. Mock integrations, such as cors preflights, are ignored.
+func gatewayIntegrates(sess *session.Session, apiId *string, functionArn string) (bool, bool, error) {
+	integrated, shared := false, false
+	err := apigateway.New(sess).GetResourcesPages(&apigateway.GetResourcesInput{
+		Embed:     []*string{aws.String("methods")},
+		RestApiId: apiId,
+	}, func(output *apigateway.GetResourcesOutput, _ bool) bool {
+		for _, r := range output.Items {
+			for _, method := range r.ResourceMethods {
+				if method.MethodIntegration == nil || aws.StringValue(method.MethodIntegration.Type) == apigateway.IntegrationTypeMock {
+					continue
+				}
+				uri := aws.StringValue(method.MethodIntegration.Uri)
+				if strings.Contains(uri, functionArn+"/") || strings.Contains(uri, functionArn+":") {
+					integrated = true
+				} else {
+					shared = true
+				}
+			}
+		}
+		return true
+	})
+	return integrated, shared, err
+}
+
+// LambdaAdopt tag the imported function and its resources as managed by awsl, its role was not created by awsl so it
+// is marked as external and never modified nor deleted
+func LambdaAdopt(sess *session.Session, name, id string) error {
+	resourceName := fmt.Sprintf("%s-%s", name, id)
+	function := LambdaGet(sess, resourceName)
+	if function == nil {
+		return fmt.Errorf("function %s not found", resourceName)
+	}
+	if err := lambdaTag(sess, *function.Configuration.FunctionArn, map[string]string{tagRoleOwner: roleOwnerExternal}, nil); err != nil {
+		return err
+	}
+	return TagsApply(sess, resourceName, LambdaTags(name, id, true, nil), nil)
+}
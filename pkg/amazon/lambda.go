@@ -1,22 +1,19 @@
 package amazon
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"strings"
 	"time"
 
+	"aws-test/pkg/manifest"
 	"aws-test/pkg/util"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/apigateway"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
-const lambdaAssumeRolePolicyDocument = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":["apigateway.amazonaws.com","logs.amazonaws.com","lambda.amazonaws.com"]},"Action":"sts:AssumeRole"}]}`
-
 type Function struct {
 	*lambda.FunctionConfiguration
 	Tags map[string]*string
@@ -36,12 +33,18 @@ func LambdaGet(sess *session.Session, name string) *lambda.GetFunctionOutput {
 
 func LambdaGetAll(sess *session.Session, all bool) ([]Function, error) {
 	l := lambda.New(sess)
-	la, err := l.ListFunctions(&lambda.ListFunctionsInput{})
+
+	var functions []*lambda.FunctionConfiguration
+	err := l.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(output *lambda.ListFunctionsOutput, _ bool) bool {
+		functions = append(functions, output.Functions...)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+
 	var list []Function
-	for _, lam := range la.Functions {
+	for _, lam := range functions {
 		output, err := l.ListTags(&lambda.ListTagsInput{
 			Resource: lam.FunctionArn,
 		})
@@ -52,170 +55,133 @@ func LambdaGetAll(sess *session.Session, all bool) ([]Function, error) {
 			list = append(list, Function{lam, output.Tags})
 			continue
 		}
-		i, ok := output.Tags["manager"]
-		if ok && *i == "awsl" {
+		i, ok := output.Tags[tagManager]
+		if ok && *i == managerAwsl {
 			list = append(list, Function{lam, output.Tags})
 		}
 	}
 	return list, nil
 }
 
-func LambdaCreate(sess *session.Session, id, runtime, name, s3Key string) (link *string, err error) {
+// LambdaCreate create the role, the function and the rest api of the lambda then deploy it on the stage of the
+// manifest. Every created resource is recorded in the journal.
+func LambdaCreate(ctx context.Context, sess *session.Session, journal *util.Journal, id, name, s3Key string, m *manifest.Manifest) (link *string, err error) {
 	var cfg *lambda.FunctionConfiguration
 
-	output, _ := iam.New(sess).GetUser(&iam.GetUserInput{})
-	accountId := strings.Split(*output.User.Arn, ":")[4]
-
 	l := lambda.New(sess)
-	rolesOutput, err := iam.New(sess).CreateRole(&iam.CreateRoleInput{
-		AssumeRolePolicyDocument: aws.String(lambdaAssumeRolePolicyDocument),
-		MaxSessionDuration:       aws.Int64(3600),
-		Path:                     aws.String("/service-role/"),
-		RoleName:                 aws.String(name),
-	})
-	if err != nil {
-		return nil, err
+
+	var role *iam.Role
+	roleOwner := roleOwnerAwsl
+	if m.Role != "" {
+		roleOwner = roleOwnerExternal
+		role, err = RoleValidate(sess, m.Role)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		role, err = RoleCreate(sess, name, m)
+		if errorCode(err) == iam.ErrCodeEntityAlreadyExistsException {
+			// Left by a previous deploy that failed, it is reused
+			var output *iam.GetRoleOutput
+			output, err = iam.New(sess).GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+			if err != nil {
+				return nil, err
+			}
+			role = output.Role
+		} else if err != nil {
+			return nil, err
+		} else {
+			journal.Record(fmt.Sprintf("role %s", name), func() error {
+				return RoleDelete(sess, name)
+			})
+		}
+		if err := RoleReconcile(sess, name, m); err != nil {
+			return nil, err
+		}
 	}
 
-	time.Sleep(3 * time.Second)
+	var deadLetter *lambda.DeadLetterConfig
+	if m.Async != nil && m.Async.DeadLetterQueue != "" {
+		deadLetter = &lambda.DeadLetterConfig{TargetArn: aws.String(m.Async.DeadLetterQueue)}
+	}
 
-	err = util.NewBackoff("create function", func() error {
+	err = util.NewBackoff(ctx, "create function", func() error {
 		cfg, err = l.CreateFunction(&lambda.CreateFunctionInput{
 			Code: &lambda.FunctionCode{
 				S3Bucket: aws.String(name),
 				S3Key:    aws.String(s3Key),
 			},
-			Role:         rolesOutput.Role.Arn,
-			FunctionName: aws.String(name),
-			Handler:      aws.String("main"),
-			MemorySize:   aws.Int64(256),
-			Publish:      aws.Bool(true),
-			Runtime:      aws.String(runtime),
+			DeadLetterConfig: deadLetter,
+			Role:             role.Arn,
+			FunctionName:     aws.String(name),
+			Handler:          aws.String("main"),
+			MemorySize:       aws.Int64(256),
+			Publish:          aws.Bool(true),
+			Runtime:          aws.String(m.Runtime),
 			Tags: map[string]*string{
-				"manager": aws.String("awsl"),
-				"created": aws.String(fmt.Sprintf("%d", time.Now().Unix())),
-				"id":      aws.String(id),
+				tagManager:   aws.String(managerAwsl),
+				tagCreated:   aws.String(fmt.Sprintf("%d", time.Now().Unix())),
+				tagId:        aws.String(id),
+				tagRoleOwner: aws.String(roleOwner),
 			},
 			Timeout: aws.Int64(15),
 		})
 		return err
-	}).Execute()
+	}).WithRetryable(func(err error) bool {
+		return roleNotReady(err) || permissionsNotReady(err)
+	}).WithOnRetry(util.ActionRetry).Execute()
 
 	if err != nil {
 		return nil, err
 	}
-
-	gateway := apigateway.New(sess)
-
-	api, errx := gateway.CreateRestApi(&apigateway.CreateRestApiInput{
-		ApiKeySource:     aws.String("HEADER"),
-		BinaryMediaTypes: []*string{aws.String("*/*")},
-		EndpointConfiguration: &apigateway.EndpointConfiguration{
-			Types: []*string{aws.String("REGIONAL")},
-		},
-		Name: aws.String(fmt.Sprintf("%s-API", name)),
-	})
-	if errx != nil {
-		return nil, errx
-	}
-
-	resources, errx := gateway.GetResources(&apigateway.GetResourcesInput{
-		RestApiId: api.Id,
-	})
-
-	if errx != nil {
-		return nil, errx
-	}
-
-	if len(resources.Items) != 1 {
-		return nil, errors.New("bad api gateway construction")
-	}
-
-	parentResourceId := resources.Items[0].Id
-
-	resource, errx := gateway.CreateResource(&apigateway.CreateResourceInput{
-		ParentId:  parentResourceId,
-		PathPart:  aws.String(name),
-		RestApiId: api.Id,
-	})
-
-	if errx != nil {
-		return nil, errx
-	}
-
-	_, errx = gateway.PutMethod(&apigateway.PutMethodInput{
-		ApiKeyRequired:    aws.Bool(false),
-		AuthorizationType: aws.String("NONE"),
-		HttpMethod:        aws.String("ANY"),
-		ResourceId:        resource.Id,
-		RestApiId:         api.Id,
-	})
-
-	if errx != nil {
-		return nil, errx
-	}
-
-	_, errx = gateway.PutIntegration(&apigateway.PutIntegrationInput{
-		HttpMethod:            aws.String("ANY"),
-		IntegrationHttpMethod: aws.String("POST"),
-		PassthroughBehavior:   aws.String("WHEN_NO_MATCH"),
-		ResourceId:            resource.Id,
-		RestApiId:             api.Id,
-		TimeoutInMillis:       aws.Int64(29000),
-		Type:                  aws.String("AWS_PROXY"),
-		Uri:                   aws.String(fmt.Sprintf("arn:aws:apigateway:eu-west-3:lambda:path/2015-03-31/functions/%s/invocations", *cfg.FunctionArn)),
+	journal.Record(fmt.Sprintf("function %s", name), func() error {
+		_, err := l.DeleteFunction(&lambda.DeleteFunctionInput{FunctionName: aws.String(name)})
+		return err
 	})
 
-	if errx != nil {
-		return nil, errx
+	if _, err := GatewayReconcile(sess, journal, name, *cfg.FunctionArn, m); err != nil {
+		return nil, err
 	}
 
-	_, errx = gateway.PutIntegrationResponse(&apigateway.PutIntegrationResponseInput{
-		HttpMethod:       aws.String("ANY"),
-		ResourceId:       resource.Id,
-		RestApiId:        api.Id,
-		SelectionPattern: aws.String(".*"),
-		StatusCode:       aws.String("200"),
-	})
-
-	if errx != nil {
-		return nil, errx
+	lambdaLink, err := StageDeploy(sess, name, m.Stage, *cfg.Version, m.Stages[m.Stage], true)
+	if err != nil {
+		return nil, err
 	}
+	return &lambdaLink, nil
+}
 
-	_, errx = gateway.PutMethodResponse(&apigateway.PutMethodResponseInput{
-		HttpMethod: aws.String("ANY"),
-		ResourceId: resource.Id,
-		RestApiId:  api.Id,
-		StatusCode: aws.String("200"),
-	})
-	if errx != nil {
-		return nil, errx
-	}
+// LambdaOwnRole tell if the execution role of the lambda was created by awsl
+func LambdaOwnRole(function *lambda.GetFunctionOutput) bool {
+	return aws.StringValue(function.Tags[tagRoleOwner]) != roleOwnerExternal
+}
 
-	_, errx = gateway.CreateDeployment(&apigateway.CreateDeploymentInput{
-		Description: aws.String("Created by awsl"),
-		RestApiId:   api.Id,
-		StageName:   aws.String("default"),
-	})
-	if errx != nil {
-		return nil, errx
+// LambdaRolePut make the existing role the execution role of the function and mark it as external so it is never
+// modified nor deleted. The role awsl created for the function is left in place and deleted with the lambda.
+func LambdaRolePut(ctx context.Context, sess *session.Session, function *lambda.GetFunctionOutput, role *iam.Role) error {
+	l := lambda.New(sess)
+	name := aws.StringValue(function.Configuration.FunctionName)
+
+	if aws.StringValue(function.Configuration.Role) != aws.StringValue(role.Arn) {
+		err := util.NewBackoff(ctx, "update execution role", func() error {
+			_, err := l.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+				FunctionName: aws.String(name),
+				Role:         role.Arn,
+			})
+			return err
+		}).WithRetryable(roleNotReady).WithOnRetry(util.ActionRetry).Execute()
+		if err != nil {
+			return err
+		}
+		// The code can't be updated until the configuration is
+		if err := l.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{FunctionName: aws.String(name)}); err != nil {
+			return err
+		}
 	}
 
-	_, errx = l.AddPermission(&lambda.AddPermissionInput{
-		Action:       aws.String("lambda:InvokeFunction"),
-		Principal:    aws.String("apigateway.amazonaws.com"),
-		FunctionName: cfg.FunctionName,
-		SourceArn: aws.String(fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/*/*/%s",
-			*sess.Config.Region, accountId, *api.Id, name,
-		)),
-		StatementId: api.Name,
-	})
-	if errx != nil {
-		return nil, errx
+	if LambdaOwnRole(function) {
+		return lambdaTag(sess, *function.Configuration.FunctionArn, map[string]string{tagRoleOwner: roleOwnerExternal}, nil)
 	}
-
-	lambdaLink := fmt.Sprintf("https://%s.execute-api.%s.amazonaws.com/default/%s", *api.Id, *sess.Config.Region, name)
-	return &lambdaLink, err
+	return nil
 }
 
 func LambdaUpdateCode(sess *session.Session, name, s3Key string) (*lambda.FunctionConfiguration, error) {
@@ -227,36 +193,3 @@ func LambdaUpdateCode(sess *session.Session, name, s3Key string) (*lambda.Functi
 		S3Key:        aws.String(s3Key),
 	})
 }
-
-func LambdaDelete(sess *session.Session, name string) error {
-	_, err := iam.New(sess).DeleteRole(&iam.DeleteRoleInput{
-		RoleName: aws.String(name),
-	})
-	if err != nil {
-		return err
-	}
-	_, err = lambda.New(sess).DeleteFunction(&lambda.DeleteFunctionInput{
-		FunctionName: aws.String(name),
-	})
-	if err != nil {
-		return err
-	}
-
-	gateway := apigateway.New(sess)
-	l, err := gateway.GetRestApis(&apigateway.GetRestApisInput{})
-	if err == nil {
-		for _, i := range l.Items {
-			if *i.Name == fmt.Sprintf("%s-API", name) {
-				_, err = gateway.DeleteRestApi(&apigateway.DeleteRestApiInput{
-					RestApiId: i.Id,
-				})
-				if err != nil {
-					return err
-				}
-			}
-		}
-	} else {
-		return err
-	}
-	return nil
-}
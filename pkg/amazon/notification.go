@@ -0,0 +1,273 @@
+package amazon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// notificationStatementPrefix start the statements of the resource policy allowing buckets to invoke the lambda, the
+// policy is how awsl find the buckets notifying it
+const notificationStatementPrefix = "s3-"
+
+func notificationStatementId(bucket string) string {
+	return notificationStatementPrefix + strings.Replace(bucket, ".", "_", -1)
+}
+
+// notificationsReconcile make the buckets of the manifest notify the alias of the stage. Entries of other functions
+// and other kinds of notifications of the buckets are kept, buckets no longer in the manifest stop notifying the
+// lambda. Buckets notifying it for the first time are recorded in the journal.
+func notificationsReconcile(sess *session.Session, journal *util.Journal, name, stage, aliasArn string, m *manifest.Manifest) error {
+	account, err := AccountGet(sess)
+	if err != nil {
+		return err
+	}
+
+	l := lambda.New(sess)
+
+	var buckets []string
+	wanted := map[string][]*s3.LambdaFunctionConfiguration{}
+	if m.Triggers != nil {
+		for i, b := range m.Triggers.S3 {
+			if _, ok := wanted[b.Bucket]; !ok {
+				buckets = append(buckets, b.Bucket)
+			}
+			wanted[b.Bucket] = append(wanted[b.Bucket], notificationConfiguration(fmt.Sprintf("%s-%s-%d", name, stage, i), aliasArn, b))
+		}
+	}
+
+	previous, err := notificationBuckets(sess, name, stage)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, b := range previous {
+		known[b] = true
+		if _, ok := wanted[b]; !ok {
+			buckets = append(buckets, b)
+		}
+	}
+
+	for _, bucket := range buckets {
+		bucket := bucket
+		configurations := wanted[bucket]
+
+		// S3 check it is allowed to invoke the function when the notification is put
+		if len(configurations) > 0 {
+			_, err := l.AddPermission(&lambda.AddPermissionInput{
+				Action:        aws.String("lambda:InvokeFunction"),
+				FunctionName:  aws.String(name),
+				Principal:     aws.String("s3.amazonaws.com"),
+				Qualifier:     aws.String(stage),
+				SourceAccount: aws.String(account.Id),
+				SourceArn:     aws.String(account.BucketArn(bucket)),
+				StatementId:   aws.String(notificationStatementId(bucket)),
+			})
+			if err != nil && errorCode(err) != lambda.ErrCodeResourceConflictException {
+				return err
+			}
+		}
+
+		changed, err := notificationPut(sess, bucket, aliasArn, configurations)
+		if err != nil {
+			return err
+		}
+		if changed && !known[bucket] {
+			journal.Record(fmt.Sprintf("notification of bucket %s", bucket), func() error {
+				_, err := notificationPut(sess, bucket, aliasArn, nil)
+				return err
+			})
+		}
+
+		if len(configurations) == 0 {
+			_, err := l.RemovePermission(&lambda.RemovePermissionInput{
+				FunctionName: aws.String(name),
+				Qualifier:    aws.String(stage),
+				StatementId:  aws.String(notificationStatementId(bucket)),
+			})
+			if err != nil && errorCode(err) != lambda.ErrCodeResourceNotFoundException {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func notificationConfiguration(id, aliasArn string, b *manifest.S3) *s3.LambdaFunctionConfiguration {
+	c := &s3.LambdaFunctionConfiguration{
+		Events:            aws.StringSlice(b.Events),
+		Id:                aws.String(id),
+		LambdaFunctionArn: aws.String(aliasArn),
+	}
+
+	var rules []*s3.FilterRule
+	if b.Prefix != "" {
+		rules = append(rules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNamePrefix), Value: aws.String(b.Prefix)})
+	}
+	if b.Suffix != "" {
+		rules = append(rules, &s3.FilterRule{Name: aws.String(s3.FilterRuleNameSuffix), Value: aws.String(b.Suffix)})
+	}
+	if len(rules) > 0 {
+		c.Filter = &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{FilterRules: rules}}
+	}
+	return c
+}
+
+// notificationPut replace the entries invoking the target in the notification configuration of the bucket by the
+// given ones, the rest of the configuration, including the entries of the other aliases of the function, is kept as
+// is. An unqualified function arn targets the function and all its aliases. It returns false when they were already
+// the same.
+func notificationPut(sess *session.Session, bucket, targetArn string, configurations []*s3.LambdaFunctionConfiguration) (bool, error) {
+	s := s3.New(sess)
+
+	current, err := s.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var kept, own []*s3.LambdaFunctionConfiguration
+	for _, c := range current.LambdaFunctionConfigurations {
+		if targetsFunction(aws.StringValue(c.LambdaFunctionArn), targetArn) {
+			own = append(own, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if notificationKeys(own) == notificationKeys(configurations) {
+		return false, nil
+	}
+
+	_, err = s.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+		Bucket: aws.String(bucket),
+		NotificationConfiguration: &s3.NotificationConfiguration{
+			EventBridgeConfiguration:     current.EventBridgeConfiguration,
+			LambdaFunctionConfigurations: append(kept, configurations...),
+			QueueConfigurations:          current.QueueConfigurations,
+			TopicConfigurations:          current.TopicConfigurations,
+		},
+	})
+	return err == nil, err
+}
+
+// notificationKeys describe the configurations regardless of their order and ids so they can be compared
+func notificationKeys(configurations []*s3.LambdaFunctionConfiguration) string {
+	var keys []string
+	for _, c := range configurations {
+		events := aws.StringValueSlice(c.Events)
+		sort.Strings(events)
+		var rules []string
+		if c.Filter != nil && c.Filter.Key != nil {
+			for _, r := range c.Filter.Key.FilterRules {
+				rules = append(rules, strings.ToLower(aws.StringValue(r.Name))+"="+aws.StringValue(r.Value))
+			}
+		}
+		sort.Strings(rules)
+		keys = append(keys, fmt.Sprintf("%s %s %s", aws.StringValue(c.LambdaFunctionArn), strings.Join(events, ","), strings.Join(rules, ",")))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+// notificationBuckets return the buckets allowed to invoke the function or one of its aliases by its resource policy
+func notificationBuckets(sess *session.Session, name, qualifier string) ([]string, error) {
+	sources, err := permissionSources(sess, name, qualifier, notificationStatementPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []string
+	for _, arn := range sources {
+		buckets = append(buckets, arn[strings.LastIndex(arn, ":")+1:])
+	}
+	return buckets, nil
+}
+
+// notificationRemove remove the entries of the function from the notification configuration of every bucket
+// allowed to invoke it or one of its aliases, nothing else is changed on the buckets
+func notificationRemove(sess *session.Session, name, functionArn string) []Removal {
+	var removals []Removal
+
+	qualifiers, err := functionQualifiers(sess, name)
+	if err != nil {
+		return append(removals, newRemoval(fmt.Sprintf("bucket notifications of %s", name), err))
+	}
+
+	seen := map[string]bool{}
+	for _, q := range qualifiers {
+		buckets, err := notificationBuckets(sess, name, q)
+		if err != nil {
+			removals = append(removals, newRemoval(fmt.Sprintf("bucket notifications of %s", name), err))
+			continue
+		}
+		for _, b := range buckets {
+			if seen[b] {
+				continue
+			}
+			seen[b] = true
+			_, err := notificationPut(sess, b, functionArn, nil)
+			removals = append(removals, newRemoval(fmt.Sprintf("notification of bucket %s", b), err))
+		}
+	}
+	return removals
+}
+
+// notificationTriggers return the entries of the function in the notification configuration of the buckets allowed
+// to invoke it or one of its aliases
+func notificationTriggers(sess *session.Session, name, functionArn string) ([]Trigger, error) {
+	var list []Trigger
+
+	qualifiers, err := functionQualifiers(sess, name)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, q := range qualifiers {
+		buckets, err := notificationBuckets(sess, name, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			if seen[b] {
+				continue
+			}
+			seen[b] = true
+
+			output, err := s3.New(sess).GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+				Bucket: aws.String(b),
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range output.LambdaFunctionConfigurations {
+				if unqualifiedFunctionArn(aws.StringValue(c.LambdaFunctionArn)) != functionArn {
+					continue
+				}
+				details := []string{strings.Join(aws.StringValueSlice(c.Events), " ")}
+				if c.Filter != nil && c.Filter.Key != nil {
+					for _, r := range c.Filter.Key.FilterRules {
+						details = append(details, fmt.Sprintf("%s %s", strings.ToLower(aws.StringValue(r.Name)), aws.StringValue(r.Value)))
+					}
+				}
+				list = append(list, Trigger{
+					Kind:    TriggerS3,
+					Source:  b,
+					Stage:   functionQualifier(aws.StringValue(c.LambdaFunctionArn)),
+					State:   "Enabled",
+					Details: strings.Join(details, ", "),
+				})
+			}
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,224 @@
+package amazon
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Status of the removal of a resource
+const (
+	RemovalDeleted  = "deleted"
+	RemovalNotFound = "not found"
+	RemovalKept     = "kept"
+	RemovalFailed   = "failed"
+)
+
+// Removal is the outcome of the removal of one resource of a lambda
+type Removal struct {
+	Resource string
+	Status   string
+	Err      error
+}
+
+// newRemoval classify the error returned by the deletion of a resource, resources already missing are not failures
+func newRemoval(resource string, err error) Removal {
+	switch errorCode(err) {
+	case "":
+		if err == nil {
+			return Removal{Resource: resource, Status: RemovalDeleted}
+		}
+	case lambda.ErrCodeResourceNotFoundException, apigateway.ErrCodeNotFoundException, iam.ErrCodeNoSuchEntityException,
+		s3.ErrCodeNoSuchBucket:
+		return Removal{Resource: resource, Status: RemovalNotFound}
+	}
+	return Removal{Resource: resource, Status: RemovalFailed, Err: err}
+}
+
+// LambdaRemove delete every resource belonging to the lambda in dependency order, a failure does not stop the removal
+// of the other resources. The bucket holding the code is only deleted when storage is true.
+func LambdaRemove(sess *session.Session, name string, storage bool) []Removal {
+	var removals []Removal
+
+	function := LambdaGet(sess, name)
+
+	removals = append(removals, gatewayRemove(sess, name)...)
+
+	// Event source mappings and scalable targets outlive the function, they are found by its arn and name
+	if account, err := AccountGet(sess); err != nil {
+		removals = append(removals, newRemoval(fmt.Sprintf("event source mappings of %s", name), err))
+	} else {
+		removals = append(removals, eventSourceRemove(sess, account.FunctionArn(name))...)
+	}
+	removals = append(removals, scalingRemove(sess, name)...)
+
+	// The buckets and topics invoking the function are only known by its resource policy
+	if function != nil {
+		removals = append(removals, notificationRemove(sess, name, *function.Configuration.FunctionArn)...)
+		removals = append(removals, subscriptionRemove(sess, name, *function.Configuration.FunctionArn)...)
+
+		err := lambda.New(sess).ListAliasesPages(&lambda.ListAliasesInput{FunctionName: aws.String(name)}, func(output *lambda.ListAliasesOutput, _ bool) bool {
+			for _, a := range output.Aliases {
+				_, err := lambda.New(sess).DeleteAlias(&lambda.DeleteAliasInput{
+					FunctionName: aws.String(name),
+					Name:         a.Name,
+				})
+				removals = append(removals, newRemoval(fmt.Sprintf("alias %s", *a.Name), err))
+			}
+			return true
+		})
+		if err != nil {
+			removals = append(removals, newRemoval(fmt.Sprintf("aliases of %s", name), err))
+		}
+	}
+
+	removals = append(removals, scheduleRemove(sess, name)...)
+
+	// Versions and resource policies of the function are deleted with it
+	_, err := lambda.New(sess).DeleteFunction(&lambda.DeleteFunctionInput{
+		FunctionName: aws.String(name),
+	})
+	removals = append(removals, newRemoval(fmt.Sprintf("function %s with its versions", name), err))
+
+	// The role awsl created is left unused when an external role replaced it, it is then only known by its tag
+	if function == nil || LambdaOwnRole(function) || roleCreatedByAwsl(sess, name) {
+		removals = append(removals, roleRemove(sess, name)...)
+	}
+	if function != nil && !LambdaOwnRole(function) {
+		removals = append(removals, Removal{Resource: fmt.Sprintf("role of %s (external)", name), Status: RemovalKept})
+	}
+
+	logGroup := fmt.Sprintf("/aws/lambda/%s", name)
+	_, err = cloudwatchlogs.New(sess).DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{
+		LogGroupName: aws.String(logGroup),
+	})
+	if errorCode(err) == cloudwatchlogs.ErrCodeResourceNotFoundException {
+		removals = append(removals, Removal{Resource: fmt.Sprintf("log group %s", logGroup), Status: RemovalNotFound})
+	} else {
+		removals = append(removals, newRemoval(fmt.Sprintf("log group %s", logGroup), err))
+	}
+
+	if storage {
+		removals = append(removals, newRemoval(fmt.Sprintf("bucket %s", name), S3DeleteBucket(sess, name)))
+	} else {
+		removals = append(removals, Removal{Resource: fmt.Sprintf("bucket %s", name), Status: RemovalKept})
+	}
+
+	return removals
+}
+
+// gatewayRemove delete the rest apis of the lambda with their domain mappings, usage plan and authorizer permission,
+// and the http or websocket apis with the same name
+func gatewayRemove(sess *session.Session, name string) []Removal {
+	var removals []Removal
+
+	gateway := apigateway.New(sess)
+
+	var apis []*apigateway.RestApi
+	err := gateway.GetRestApisPages(&apigateway.GetRestApisInput{}, func(output *apigateway.GetRestApisOutput, _ bool) bool {
+		for _, i := range output.Items {
+			if aws.StringValue(i.Name) == gatewayName(name) {
+				apis = append(apis, i)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return append(removals, newRemoval(fmt.Sprintf("rest api %s", gatewayName(name)), err))
+	}
+	if len(apis) == 0 {
+		removals = append(removals, Removal{Resource: fmt.Sprintf("rest api %s", gatewayName(name)), Status: RemovalNotFound})
+	}
+
+	for _, api := range apis {
+		domains, err := domainListByApi(sess, *api.Id)
+		if err != nil {
+			removals = append(removals, newRemoval(fmt.Sprintf("domains of rest api %s", *api.Id), err))
+		}
+		for _, d := range domains {
+			removals = append(removals, newRemoval(fmt.Sprintf("domain mapping %s", d.Name), domainUnmap(sess, *api.Id, d.Name)))
+		}
+
+		if plan, err := usagePlanGet(sess, name); err != nil || plan != nil {
+			if err == nil {
+				err = usagePlanDelete(sess, name)
+			}
+			removals = append(removals, newRemoval(fmt.Sprintf("usage plan %s", usagePlanName(name)), err))
+		}
+
+		if a, err := authorizerGet(sess, name, api.Id); err != nil || a != nil {
+			if err == nil {
+				err = authorizerRevoke(sess, name, a)
+			}
+			removals = append(removals, newRemoval(fmt.Sprintf("permission of the authorizer %s", authorizerName(name)), err))
+		}
+
+		_, err = gateway.DeleteRestApi(&apigateway.DeleteRestApiInput{RestApiId: api.Id})
+		removals = append(removals, newRemoval(fmt.Sprintf("rest api %s (%s)", gatewayName(name), *api.Id), err))
+	}
+
+	v2 := apigatewayv2.New(sess)
+	input := &apigatewayv2.GetApisInput{}
+	for {
+		output, err := v2.GetApis(input)
+		if err != nil {
+			return append(removals, newRemoval(fmt.Sprintf("api %s", gatewayName(name)), err))
+		}
+		for _, api := range output.Items {
+			if aws.StringValue(api.Name) != gatewayName(name) {
+				continue
+			}
+			_, err := v2.DeleteApi(&apigatewayv2.DeleteApiInput{ApiId: api.ApiId})
+			removals = append(removals, newRemoval(fmt.Sprintf("%s api %s (%s)", aws.StringValue(api.ProtocolType), gatewayName(name), *api.ApiId), err))
+		}
+		if output.NextToken == nil {
+			break
+		}
+		input.NextToken = output.NextToken
+	}
+
+	return removals
+}
+
+// roleRemove detach and delete the policies of the execution role of the lambda then delete it
+func roleRemove(sess *session.Session, name string) []Removal {
+	var removals []Removal
+
+	i := iam.New(sess)
+
+	attached, err := roleAttachedPolicies(sess, name)
+	if err != nil {
+		return append(removals, newRemoval(fmt.Sprintf("role %s", name), err))
+	}
+	for _, p := range attached {
+		_, err := i.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			RoleName:  aws.String(name),
+			PolicyArn: aws.String(p),
+		})
+		removals = append(removals, newRemoval(fmt.Sprintf("attachment of policy %s", p), err))
+	}
+
+	inline, err := roleInlinePolicies(sess, name)
+	if err != nil {
+		removals = append(removals, newRemoval(fmt.Sprintf("inline policies of role %s", name), err))
+	}
+	for _, p := range inline {
+		_, err := i.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(name),
+			PolicyName: aws.String(p),
+		})
+		removals = append(removals, newRemoval(fmt.Sprintf("inline policy %s", p), err))
+	}
+
+	_, err = i.DeleteRole(&iam.DeleteRoleInput{
+		RoleName: aws.String(name),
+	})
+	return append(removals, newRemoval(fmt.Sprintf("role %s", name), err))
+}
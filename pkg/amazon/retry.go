@@ -0,0 +1,38 @@
+package amazon
+
+import (
+	"time"
+
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retryer retry the throttled and failed aws calls, as the sdk would, but with the delays of a util.Backoff
+type retryer struct {
+	client.DefaultRetryer
+	backoff *util.Backoff
+}
+
+// NewRetryer return a retryer to set in the config of a session so every aws call follow the backoff
+func NewRetryer(backoff *util.Backoff) request.Retryer {
+	return retryer{backoff: backoff}
+}
+
+func (r retryer) MaxRetries() int {
+	return r.backoff.MaxAttempt() - 1
+}
+
+func (r retryer) ShouldRetry(req *request.Request) bool {
+	if budget := r.backoff.Budget(); budget > 0 && time.Since(req.Time) > budget {
+		return false
+	}
+	return r.DefaultRetryer.ShouldRetry(req)
+}
+
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	attempt := req.RetryCount + 1
+	r.backoff.NotifyRetry(attempt+1, req.Error)
+	return r.backoff.Delay(attempt)
+}
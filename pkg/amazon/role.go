@@ -0,0 +1,306 @@
+package amazon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"aws-test/pkg/manifest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// lambdaAssumeRolePolicyDocument only let lambda assume the execution role
+const lambdaAssumeRolePolicyDocument = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+
+// Tag recording who owns the execution role of a lambda, roles owned by someone else are never modified nor deleted
+const (
+	tagRoleOwner      = "role-owner"
+	roleOwnerAwsl     = "awsl"
+	roleOwnerExternal = "external"
+)
+
+// basicExecutionPolicy allow the lambda to write its logs, it is attached to every role created by awsl
+const basicExecutionPolicy = "service-role/AWSLambdaBasicExecutionRole"
+
+// RoleCreate create the execution role of the lambda
+func RoleCreate(sess *session.Session, name string, m *manifest.Manifest) (*iam.Role, error) {
+	input := &iam.CreateRoleInput{
+		AssumeRolePolicyDocument: aws.String(lambdaAssumeRolePolicyDocument),
+		MaxSessionDuration:       aws.Int64(3600),
+		Path:                     aws.String("/service-role/"),
+		RoleName:                 aws.String(name),
+		// Roles are global, the region tells gc where to look for the lambda using it
+		Tags: []*iam.Tag{
+			{Key: aws.String(tagManager), Value: aws.String(managerAwsl)},
+			{Key: aws.String(tagRegion), Value: sess.Config.Region},
+		},
+	}
+	if m.PermissionsBoundary != "" {
+		input.PermissionsBoundary = aws.String(m.PermissionsBoundary)
+	}
+	output, err := iam.New(sess).CreateRole(input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Role, nil
+}
+
+// RoleReconcile restrict the trust policy of the execution role of the lambda to lambda, attach the managed policies
+// and put the inline policies of the manifest to the role, with the policies allowing it to read the event sources of
+// the triggers and to send the results of the asynchronous invocations of the stage. Policies no longer in the manifest
+// are removed, the ones of the other stages are kept.
+func RoleReconcile(sess *session.Session, name string, m *manifest.Manifest) error {
+	account, err := AccountGet(sess)
+	if err != nil {
+		return err
+	}
+
+	i := iam.New(sess)
+
+	role, err := i.GetRole(&iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		return err
+	}
+
+	// Roles created by older versions also trusted api gateway and cloudwatch logs
+	trust, err := url.QueryUnescape(aws.StringValue(role.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return err
+	}
+	if !sameJson(trust, lambdaAssumeRolePolicyDocument) {
+		_, err := i.UpdateAssumeRolePolicy(&iam.UpdateAssumeRolePolicyInput{
+			PolicyDocument: aws.String(lambdaAssumeRolePolicyDocument),
+			RoleName:       aws.String(name),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	currentBoundary := ""
+	if role.Role.PermissionsBoundary != nil {
+		currentBoundary = aws.StringValue(role.Role.PermissionsBoundary.PermissionsBoundaryArn)
+	}
+	if currentBoundary != m.PermissionsBoundary {
+		if m.PermissionsBoundary == "" {
+			_, err = i.DeleteRolePermissionsBoundary(&iam.DeleteRolePermissionsBoundaryInput{
+				RoleName: aws.String(name),
+			})
+		} else {
+			_, err = i.PutRolePermissionsBoundary(&iam.PutRolePermissionsBoundaryInput{
+				RoleName:            aws.String(name),
+				PermissionsBoundary: aws.String(m.PermissionsBoundary),
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	wanted := map[string]bool{account.ManagedPolicyArn(basicExecutionPolicy): true}
+	for _, p := range m.Policies {
+		wanted[p] = true
+	}
+
+	attached, err := roleAttachedPolicies(sess, name)
+	if err != nil {
+		return err
+	}
+	for _, p := range attached {
+		if wanted[p] {
+			delete(wanted, p)
+			continue
+		}
+		_, err := i.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			RoleName:  aws.String(name),
+			PolicyArn: aws.String(p),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for p := range wanted {
+		_, err := i.AttachRolePolicy(&iam.AttachRolePolicyInput{
+			RoleName:  aws.String(name),
+			PolicyArn: aws.String(p),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	inlinePolicies := map[string]string{}
+	for p, document := range m.InlinePolicies {
+		inlinePolicies[p] = document
+	}
+	document, err := triggersPolicy(account, m)
+	if err != nil {
+		return err
+	}
+	if document != "" {
+		inlinePolicies[triggersPolicyName(m.Stage)] = document
+	}
+	document, err = asyncPolicy(sess, m)
+	if err != nil {
+		return err
+	}
+	if document != "" {
+		inlinePolicies[asyncPolicyName(m.Stage)] = document
+	}
+
+	inline, err := roleInlinePolicies(sess, name)
+	if err != nil {
+		return err
+	}
+	for _, p := range inline {
+		if _, ok := inlinePolicies[p]; ok || otherStagePolicy(p, m.Stage) {
+			continue
+		}
+		_, err := i.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(name),
+			PolicyName: aws.String(p),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for p, document := range inlinePolicies {
+		_, err := i.PutRolePolicy(&iam.PutRolePolicyInput{
+			RoleName:       aws.String(name),
+			PolicyName:     aws.String(p),
+			PolicyDocument: aws.String(document),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// otherStagePolicy tell if the inline policy is the triggers or async policy of another stage than the deployed one
+func otherStagePolicy(policy, stage string) bool {
+	for _, prefix := range []string{triggersPolicyPrefix, asyncPolicyPrefix} {
+		if strings.HasPrefix(policy, prefix) && policy != prefix+stage {
+			return true
+		}
+	}
+	return false
+}
+
+// roleCreatedByAwsl tell if the role named after the lambda exists and was created by awsl
+func roleCreatedByAwsl(sess *session.Session, name string) bool {
+	tags, err := roleTags(sess, name)
+	return err == nil && tags[tagManager] == managerAwsl
+}
+
+func roleTags(sess *session.Session, name string) (map[string]string, error) {
+	tags := map[string]string{}
+	err := iam.New(sess).ListRoleTagsPages(&iam.ListRoleTagsInput{RoleName: aws.String(name)}, func(output *iam.ListRoleTagsOutput, _ bool) bool {
+		for _, t := range output.Tags {
+			tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+		}
+		return true
+	})
+	return tags, err
+}
+
+// RoleDelete detach and delete the policies of the execution role of the lambda then delete it
+func RoleDelete(sess *session.Session, name string) error {
+	for _, r := range roleRemove(sess, name) {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+func roleAttachedPolicies(sess *session.Session, name string) ([]string, error) {
+	var list []string
+	err := iam.New(sess).ListAttachedRolePoliciesPages(&iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(name),
+	}, func(output *iam.ListAttachedRolePoliciesOutput, _ bool) bool {
+		for _, p := range output.AttachedPolicies {
+			list = append(list, aws.StringValue(p.PolicyArn))
+		}
+		return true
+	})
+	return list, err
+}
+
+func roleInlinePolicies(sess *session.Session, name string) ([]string, error) {
+	var list []string
+	err := iam.New(sess).ListRolePoliciesPages(&iam.ListRolePoliciesInput{
+		RoleName: aws.String(name),
+	}, func(output *iam.ListRolePoliciesOutput, _ bool) bool {
+		list = append(list, aws.StringValueSlice(output.PolicyNames)...)
+		return true
+	})
+	return list, err
+}
+
+// RoleValidate return the role given by its name or arn after checking that lambda can assume it
+func RoleValidate(sess *session.Session, role string) (*iam.Role, error) {
+	if strings.HasPrefix(role, "arn:") {
+		role = role[strings.LastIndex(role, "/")+1:]
+	}
+
+	output, err := iam.New(sess).GetRole(&iam.GetRoleInput{RoleName: aws.String(role)})
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := url.QueryUnescape(aws.StringValue(output.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+	ok, err := trustLambda(document)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("role %s can't be assumed by lambda.amazonaws.com, add it to its trust policy", role)
+	}
+	return output.Role, nil
+}
+
+// trustLambda tell if the trust policy document allow lambda to assume the role
+func trustLambda(document string) (bool, error) {
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Action    interface{}
+			Principal struct {
+				Service interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return false, err
+	}
+
+	// Action and Service are either a string or a list of strings
+	contains := func(value interface{}, s string) bool {
+		switch v := value.(type) {
+		case string:
+			return v == s
+		case []interface{}:
+			for _, i := range v {
+				if i == s {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, s := range policy.Statement {
+		if s.Effect == "Allow" && contains(s.Action, "sts:AssumeRole") && contains(s.Principal.Service, "lambda.amazonaws.com") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,7 +1,10 @@
 package amazon
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,13 +22,18 @@ func S3BucketExist(sess *session.Session, bucketName string) bool {
 	return err == nil
 }
 
+// S3CreateBucket create the bucket storing the code of a lambda, it is tagged right away so gc can find it when the
+// deploy fails before the resources of the lambda are tagged
 func S3CreateBucket(sess *session.Session, bucketName string) error {
 	s := s3.New(sess)
 
 	_, err := s.CreateBucket(&s3.CreateBucketInput{
 		Bucket: aws.String(bucketName),
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	return bucketTag(sess, bucketName, map[string]string{tagManager: managerAwsl}, nil)
 }
 
 func S3DeleteBucket(sess *session.Session, bucketName string) error {
@@ -36,6 +44,14 @@ func S3DeleteBucket(sess *session.Session, bucketName string) error {
 	})
 
 	if err := s3manager.NewBatchDeleteWithClient(s).Delete(aws.BackgroundContext(), iter); err != nil {
+		// A missing bucket is reported by the listing of its objects, wrapped in the error of the batch
+		if batchErr, ok := err.(*s3manager.BatchError); ok {
+			for _, e := range batchErr.Errors {
+				if errorCode(e.OrigErr) == s3.ErrCodeNoSuchBucket {
+					return e.OrigErr
+				}
+			}
+		}
 		return err
 	}
 
@@ -74,6 +90,10 @@ func S3FileExist(sess *session.Session, bucketName string, sum string) bool {
 	return false
 }
 
+// codeShaMetadata is the metadata of an uploaded zip holding its sha256 the way lambda report it, so the key of the
+// code of a version can be found back
+const codeShaMetadata = "code-sha256"
+
 func S3UploadFile(sess *session.Session, bucketName, sum, file string) (string, *s3manager.UploadOutput, error) {
 	name := fmt.Sprintf("%d-%s.zip", time.Now().Unix(), sum)
 	uploader := s3manager.NewUploader(sess)
@@ -83,10 +103,19 @@ func S3UploadFile(sess *session.Session, bucketName, sum, file string) (string,
 	}
 	defer f.Close()
 
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", nil, err
+	}
+
 	output, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(name),
-		Body:   f,
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(name),
+		Body:     f,
+		Metadata: map[string]*string{codeShaMetadata: aws.String(base64.StdEncoding.EncodeToString(h.Sum(nil)))},
 	})
 	return name, output, err
 }
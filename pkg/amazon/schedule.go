@@ -0,0 +1,275 @@
+package amazon
+
+import (
+	"fmt"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// scheduleTargetId is the id of the only target of the rule of a stage
+const scheduleTargetId = "awsl"
+
+// scheduleRuleName is the rule invoking the alias of the stage, each stage has its own schedule
+func scheduleRuleName(name, stage string) string {
+	return fmt.Sprintf("%s-%s-schedule", name, stage)
+}
+
+// scheduleRuleStage return the stage of a rule of the lambda, stage names have no dashes so rules of other lambdas
+// sharing the prefix are never mistaken for one
+func scheduleRuleStage(name, ruleName string) (string, bool) {
+	prefix, suffix := name+"-", "-schedule"
+	if !strings.HasPrefix(ruleName, prefix) || !strings.HasSuffix(ruleName, suffix) || len(ruleName) <= len(prefix)+len(suffix) {
+		return "", false
+	}
+	stage := ruleName[len(prefix) : len(ruleName)-len(suffix)]
+	return stage, !strings.Contains(stage, "-")
+}
+
+// ScheduleReconcile make the rule of the stage invoke its alias on the schedule of the manifest, the rules of the
+// other stages are left as they are. The rule is kept as is when the manifest has no schedule and only deleted when
+// it is explicitly none. A created rule is recorded in the journal.
+func ScheduleReconcile(sess *session.Session, journal *util.Journal, name, stage string, m *manifest.Manifest) error {
+	events := cloudwatchevents.New(sess)
+	ruleName := scheduleRuleName(name, stage)
+
+	if m.Schedule == "" {
+		return nil
+	}
+	if m.Schedule == manifest.ScheduleNone {
+		if err := scheduleDelete(sess, name, stage); err != nil && errorCode(err) != cloudwatchevents.ErrCodeResourceNotFoundException {
+			return err
+		}
+		return nil
+	}
+
+	rule, err := events.DescribeRule(&cloudwatchevents.DescribeRuleInput{Name: aws.String(ruleName)})
+	if errorCode(err) == cloudwatchevents.ErrCodeResourceNotFoundException {
+		rule, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ruleArn *string
+	if rule == nil || aws.StringValue(rule.ScheduleExpression) != m.Schedule ||
+		aws.StringValue(rule.State) != cloudwatchevents.RuleStateEnabled {
+		output, err := events.PutRule(&cloudwatchevents.PutRuleInput{
+			Description:        aws.String(fmt.Sprintf("Schedule of the lambda %s on stage %s, managed by awsl", name, stage)),
+			Name:               aws.String(ruleName),
+			ScheduleExpression: aws.String(m.Schedule),
+			State:              aws.String(cloudwatchevents.RuleStateEnabled),
+		})
+		if err != nil {
+			return err
+		}
+		if rule == nil {
+			journal.Record(fmt.Sprintf("schedule rule %s", ruleName), func() error {
+				return scheduleDelete(sess, name, stage)
+			})
+		}
+		ruleArn = output.RuleArn
+	} else {
+		ruleArn = rule.Arn
+	}
+
+	alias, err := lambda.New(sess).GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(name),
+		Name:         aws.String(stage),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := scheduleTargetReconcile(sess, ruleName, *alias.AliasArn, m.ScheduleInput); err != nil {
+		return err
+	}
+
+	_, err = lambda.New(sess).AddPermission(&lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("events.amazonaws.com"),
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+		SourceArn:    ruleArn,
+		StatementId:  aws.String(ruleName),
+	})
+	if errorCode(err) == lambda.ErrCodeResourceConflictException {
+		err = nil
+	}
+	return err
+}
+
+// scheduleTargetReconcile make the alias the only target of the rule, with the constant input when one is given
+func scheduleTargetReconcile(sess *session.Session, ruleName, aliasArn, input string) error {
+	events := cloudwatchevents.New(sess)
+
+	output, err := events.ListTargetsByRule(&cloudwatchevents.ListTargetsByRuleInput{Rule: aws.String(ruleName)})
+	if err != nil {
+		return err
+	}
+
+	upToDate := false
+	var others []*string
+	for _, t := range output.Targets {
+		if aws.StringValue(t.Id) != scheduleTargetId {
+			others = append(others, t.Id)
+			continue
+		}
+		upToDate = aws.StringValue(t.Arn) == aliasArn && aws.StringValue(t.Input) == input
+	}
+
+	if len(others) > 0 {
+		if _, err := events.RemoveTargets(&cloudwatchevents.RemoveTargetsInput{Ids: others, Rule: aws.String(ruleName)}); err != nil {
+			return err
+		}
+	}
+	if upToDate {
+		return nil
+	}
+
+	target := &cloudwatchevents.Target{Arn: aws.String(aliasArn), Id: aws.String(scheduleTargetId)}
+	if input != "" {
+		target.Input = aws.String(input)
+	}
+	_, err = events.PutTargets(&cloudwatchevents.PutTargetsInput{
+		Rule:    aws.String(ruleName),
+		Targets: []*cloudwatchevents.Target{target},
+	})
+	return err
+}
+
+// ScheduleOf return the schedule expression of each stage of the lambda among the rules, rules of other lambdas are
+// ignored
+func ScheduleOf(name string, rules []*cloudwatchevents.Rule) map[string]string {
+	schedules := map[string]string{}
+	for _, r := range rules {
+		if stage, ok := scheduleRuleStage(name, aws.StringValue(r.Name)); ok {
+			schedules[stage] = aws.StringValue(r.ScheduleExpression)
+		}
+	}
+	return schedules
+}
+
+// ScheduleRulesList return the rules whose name start with the prefix, every rule of the region when it is empty
+func ScheduleRulesList(sess *session.Session, prefix string) ([]*cloudwatchevents.Rule, error) {
+	events := cloudwatchevents.New(sess)
+
+	var rules []*cloudwatchevents.Rule
+	input := &cloudwatchevents.ListRulesInput{}
+	if prefix != "" {
+		input.NamePrefix = aws.String(prefix)
+	}
+	for {
+		output, err := events.ListRules(input)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, output.Rules...)
+		if output.NextToken == nil {
+			return rules, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// scheduleRules return the rules of the stages of the lambda
+func scheduleRules(sess *session.Session, name string) ([]*cloudwatchevents.Rule, error) {
+	all, err := ScheduleRulesList(sess, name+"-")
+	if err != nil {
+		return nil, err
+	}
+	var rules []*cloudwatchevents.Rule
+	for _, r := range all {
+		if _, ok := scheduleRuleStage(name, aws.StringValue(r.Name)); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// scheduleDelete remove the targets of the rule of the stage, the rule itself and the permission it had to invoke
+// the alias
+func scheduleDelete(sess *session.Session, name, stage string) error {
+	events := cloudwatchevents.New(sess)
+	ruleName := scheduleRuleName(name, stage)
+
+	output, err := events.ListTargetsByRule(&cloudwatchevents.ListTargetsByRuleInput{Rule: aws.String(ruleName)})
+	if err != nil {
+		return err
+	}
+	if len(output.Targets) > 0 {
+		var ids []*string
+		for _, t := range output.Targets {
+			ids = append(ids, t.Id)
+		}
+		if _, err := events.RemoveTargets(&cloudwatchevents.RemoveTargetsInput{Ids: ids, Rule: aws.String(ruleName)}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := events.DeleteRule(&cloudwatchevents.DeleteRuleInput{Name: aws.String(ruleName)}); err != nil {
+		return err
+	}
+
+	_, err = lambda.New(sess).RemovePermission(&lambda.RemovePermissionInput{
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+		StatementId:  aws.String(ruleName),
+	})
+	if errorCode(err) == lambda.ErrCodeResourceNotFoundException {
+		err = nil
+	}
+	return err
+}
+
+// scheduleRemove delete the rules of every stage of the lambda
+func scheduleRemove(sess *session.Session, name string) []Removal {
+	rules, err := scheduleRules(sess, name)
+	if err != nil {
+		return []Removal{newRemoval(fmt.Sprintf("schedule rules of %s", name), err)}
+	}
+	var removals []Removal
+	for _, r := range rules {
+		stage, _ := scheduleRuleStage(name, aws.StringValue(r.Name))
+		removals = append(removals, newRemoval(fmt.Sprintf("schedule rule %s", *r.Name), scheduleDelete(sess, name, stage)))
+	}
+	return removals
+}
+
+// scheduleTriggers return the schedules of the stages of the lambda as triggers
+func scheduleTriggers(sess *session.Session, name string) ([]Trigger, error) {
+	events := cloudwatchevents.New(sess)
+
+	rules, err := scheduleRules(sess, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Trigger
+	for _, rule := range rules {
+		output, err := events.ListTargetsByRule(&cloudwatchevents.ListTargetsByRuleInput{Rule: rule.Name})
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range output.Targets {
+			details := aws.StringValue(rule.ScheduleExpression)
+			if t.Input != nil {
+				details += fmt.Sprintf(", input %s", *t.Input)
+			}
+			list = append(list, Trigger{
+				Kind:    TriggerSchedule,
+				Source:  aws.StringValue(rule.Name),
+				Stage:   functionQualifier(aws.StringValue(t.Arn)),
+				State:   aws.StringValue(rule.State),
+				Details: details,
+			})
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,213 @@
+package amazon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"aws-test/pkg/manifest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// stageVariableAlias is the stage variable holding the alias of the lambda invoked by a stage
+const stageVariableAlias = "alias"
+
+type Stage struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+// StageDeploy point the alias named after the stage to the version of the lambda and deploy the rest api on the
+// stage when redeploy is true or the stage does not exist yet. It returns the link of the lambda on this stage.
+func StageDeploy(sess *session.Session, name, stage, version string, settings *manifest.Stage, redeploy bool) (string, error) {
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return "", err
+	}
+	if api == nil {
+		return "", errors.New("no api gateway found for this lambda")
+	}
+
+	account, err := AccountGet(sess)
+	if err != nil {
+		return "", err
+	}
+
+	l := lambda.New(sess)
+
+	if _, err := LambdaAliasPut(sess, name, stage, version); err != nil {
+		return "", err
+	}
+
+	// The permission is given again on each deploy since the alias may have been created by hand, a permission left
+	// by a previous rest api of the lambda is replaced since it would refuse the calls of the current one
+	statementId := fmt.Sprintf("%s-%s", gatewayName(name), stage)
+	sourceArn := account.ExecuteApiArn(*api.Id, "*/*/"+name)
+	sources, err := permissionSources(sess, name, stage, statementId)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) > 0 && sources[0] != sourceArn {
+		_, err := l.RemovePermission(&lambda.RemovePermissionInput{
+			FunctionName: aws.String(name),
+			Qualifier:    aws.String(stage),
+			StatementId:  aws.String(statementId),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = l.AddPermission(&lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("apigateway.amazonaws.com"),
+		FunctionName: aws.String(name),
+		Qualifier:    aws.String(stage),
+		SourceArn:    aws.String(sourceArn),
+		StatementId:  aws.String(statementId),
+	})
+	if errorCode(err) == lambda.ErrCodeResourceConflictException {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	gateway := apigateway.New(sess)
+
+	existing, err := gateway.GetStage(&apigateway.GetStageInput{
+		RestApiId: api.Id,
+		StageName: aws.String(stage),
+	})
+	if err != nil && errorCode(err) != apigateway.ErrCodeNotFoundException {
+		return "", err
+	}
+	if existing == nil || aws.StringValue(existing.Variables[stageVariableAlias]) != stage {
+		redeploy = true
+	}
+
+	if redeploy {
+		_, err = gateway.CreateDeployment(&apigateway.CreateDeploymentInput{
+			Description: aws.String(fmt.Sprintf("Deployed by awsl, version %s", version)),
+			RestApiId:   api.Id,
+			StageName:   aws.String(stage),
+			Variables: map[string]*string{
+				stageVariableAlias: aws.String(stage),
+			},
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if settings != nil {
+		if err := stageConfigure(sess, api.Id, stage, settings); err != nil {
+			return "", err
+		}
+	}
+
+	if err := usagePlanAddStage(sess, name, api.Id, stage); err != nil {
+		return "", err
+	}
+
+	return account.ExecuteApiUrl(*api.Id, stage, name), nil
+}
+
+// StageList return every stage of the rest api of the lambda with its link
+func StageList(sess *session.Session, name string) ([]Stage, error) {
+	api, err := GatewayGet(sess, name)
+	if err != nil || api == nil {
+		return nil, err
+	}
+	return StageListByApi(sess, name, api)
+}
+
+// StageListByApi return every stage of the rest api of the lambda with its link, nothing when the api is nil
+func StageListByApi(sess *session.Session, name string, api *apigateway.RestApi) ([]Stage, error) {
+	if api == nil {
+		return nil, nil
+	}
+
+	account, err := AccountGet(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := apigateway.New(sess).GetStages(&apigateway.GetStagesInput{
+		RestApiId: api.Id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Stage
+	for _, s := range output.Item {
+		list = append(list, Stage{
+			Name: *s.StageName,
+			Url:  account.ExecuteApiUrl(*api.Id, *s.StageName, name),
+		})
+	}
+	return list, nil
+}
+
+// LambdaAliasPut create the alias or move it to the version
+func LambdaAliasPut(sess *session.Session, name, alias, version string) (*lambda.AliasConfiguration, error) {
+	l := lambda.New(sess)
+
+	_, err := l.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(name),
+		Name:         aws.String(alias),
+	})
+	if err != nil {
+		return l.CreateAlias(&lambda.CreateAliasInput{
+			FunctionName:    aws.String(name),
+			FunctionVersion: aws.String(version),
+			Name:            aws.String(alias),
+		})
+	}
+	return l.UpdateAlias(&lambda.UpdateAliasInput{
+		FunctionName:    aws.String(name),
+		FunctionVersion: aws.String(version),
+		Name:            aws.String(alias),
+	})
+}
+
+func stageConfigure(sess *session.Session, apiId *string, stage string, settings *manifest.Stage) error {
+	replace := func(path, value string) *apigateway.PatchOperation {
+		return &apigateway.PatchOperation{Op: aws.String("replace"), Path: aws.String(path), Value: aws.String(value)}
+	}
+
+	operations := []*apigateway.PatchOperation{
+		replace("/*/*/metrics/enabled", strconv.FormatBool(settings.Metrics)),
+		replace("/*/*/logging/dataTrace", strconv.FormatBool(settings.DataTrace)),
+	}
+	if settings.Throttle != nil {
+		operations = append(operations,
+			replace("/*/*/throttling/rateLimit", strconv.FormatFloat(settings.Throttle.Rate, 'f', -1, 64)),
+			replace("/*/*/throttling/burstLimit", strconv.FormatInt(settings.Throttle.Burst, 10)),
+		)
+	}
+	if settings.Logging != "" {
+		operations = append(operations, replace("/*/*/logging/loglevel", strings.ToUpper(settings.Logging)))
+	}
+
+	_, err := apigateway.New(sess).UpdateStage(&apigateway.UpdateStageInput{
+		RestApiId:       apiId,
+		StageName:       aws.String(stage),
+		PatchOperations: operations,
+	})
+	return err
+}
+
+// unqualifiedFunctionArn remove the version or alias of a function arn: arn:<partition>:lambda:<region>:<account>:function:<name>[:<qualifier>]
+func unqualifiedFunctionArn(arn string) string {
+	split := strings.Split(arn, ":")
+	if len(split) > 7 {
+		split = split[:7]
+	}
+	return strings.Join(split, ":")
+}
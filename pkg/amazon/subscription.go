@@ -0,0 +1,276 @@
+package amazon
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// subscriptionStatementPrefix start the statements of the resource policy allowing topics to invoke the lambda, the
+// policy is how awsl find the topics the lambda is subscribed to
+const subscriptionStatementPrefix = "sns-"
+
+// subscriptionStatementId derive the statement from the arn of the topic since topic names are longer than what a
+// statement id accept
+func subscriptionStatementId(topicArn string) string {
+	return fmt.Sprintf("%s%x", subscriptionStatementPrefix, sha256.Sum256([]byte(topicArn)))[:36]
+}
+
+// snsClient return a client of the region of the topic
+func snsClient(sess *session.Session, topicArn string) *sns.SNS {
+	split := strings.Split(topicArn, ":")
+	if len(split) > 3 && split[3] != aws.StringValue(sess.Config.Region) {
+		return sns.New(sess.Copy(&aws.Config{Region: aws.String(split[3])}))
+	}
+	return sns.New(sess)
+}
+
+// subscriptionsReconcile subscribe the alias of the stage to the topics of the manifest with their filter policy, the
+// lambda is unsubscribed from the topics no longer in the manifest. New subscriptions are recorded in the journal.
+func subscriptionsReconcile(sess *session.Session, journal *util.Journal, name, stage, aliasArn string, m *manifest.Manifest) error {
+	l := lambda.New(sess)
+
+	var topics []string
+	wanted := map[string]*manifest.Sns{}
+	if m.Triggers != nil {
+		for _, t := range m.Triggers.Sns {
+			topics = append(topics, t.Topic)
+			wanted[t.Topic] = t
+		}
+	}
+
+	previous, err := permissionSources(sess, name, stage, subscriptionStatementPrefix)
+	if err != nil {
+		return err
+	}
+	for _, t := range previous {
+		if _, ok := wanted[t]; !ok {
+			topics = append(topics, t)
+		}
+	}
+
+	for _, topic := range topics {
+		t, ok := wanted[topic]
+		if !ok {
+			if err := subscriptionDelete(sess, topic, aliasArn); err != nil && errorCode(err) != sns.ErrCodeNotFoundException {
+				return err
+			}
+			_, err := l.RemovePermission(&lambda.RemovePermissionInput{
+				FunctionName: aws.String(name),
+				Qualifier:    aws.String(stage),
+				StatementId:  aws.String(subscriptionStatementId(topic)),
+			})
+			if err != nil && errorCode(err) != lambda.ErrCodeResourceNotFoundException {
+				return err
+			}
+			continue
+		}
+
+		_, err := l.AddPermission(&lambda.AddPermissionInput{
+			Action:       aws.String("lambda:InvokeFunction"),
+			FunctionName: aws.String(name),
+			Principal:    aws.String("sns.amazonaws.com"),
+			Qualifier:    aws.String(stage),
+			SourceArn:    aws.String(topic),
+			StatementId:  aws.String(subscriptionStatementId(topic)),
+		})
+		if err != nil && errorCode(err) != lambda.ErrCodeResourceConflictException {
+			return err
+		}
+
+		if err := subscriptionPut(sess, journal, t, aliasArn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscriptionPut subscribe the alias to the topic or update the filter policy of its subscription, subscriptions of
+// the other aliases of the function to the topic are kept
+func subscriptionPut(sess *session.Session, journal *util.Journal, t *manifest.Sns, aliasArn string) error {
+	client := snsClient(sess, t.Topic)
+
+	subscriptions, err := subscriptionList(sess, t.Topic, aliasArn)
+	if err != nil {
+		return err
+	}
+
+	if len(subscriptions) == 0 {
+		input := &sns.SubscribeInput{
+			Endpoint:              aws.String(aliasArn),
+			Protocol:              aws.String("lambda"),
+			ReturnSubscriptionArn: aws.Bool(true),
+			TopicArn:              aws.String(t.Topic),
+		}
+		if t.FilterPolicy != "" {
+			input.Attributes = map[string]*string{"FilterPolicy": aws.String(t.FilterPolicy)}
+		}
+		output, err := client.Subscribe(input)
+		if err != nil {
+			return err
+		}
+		journal.Record(fmt.Sprintf("subscription to %s", t.Topic), func() error {
+			_, err := client.Unsubscribe(&sns.UnsubscribeInput{SubscriptionArn: output.SubscriptionArn})
+			return err
+		})
+		return nil
+	}
+
+	subscription := subscriptions[0]
+	attributes, err := client.GetSubscriptionAttributes(&sns.GetSubscriptionAttributesInput{
+		SubscriptionArn: subscription.SubscriptionArn,
+	})
+	if err != nil {
+		return err
+	}
+	current := aws.StringValue(attributes.Attributes["FilterPolicy"])
+	if sameJson(current, t.FilterPolicy) {
+		return nil
+	}
+
+	policy := t.FilterPolicy
+	if policy == "" {
+		policy = "{}"
+	}
+	_, err = client.SetSubscriptionAttributes(&sns.SetSubscriptionAttributesInput{
+		AttributeName:   aws.String("FilterPolicy"),
+		AttributeValue:  aws.String(policy),
+		SubscriptionArn: subscription.SubscriptionArn,
+	})
+	return err
+}
+
+// sameJson tell if two json documents hold the same value, an empty document is the same as an empty object
+func sameJson(a, b string) bool {
+	decode := func(s string) interface{} {
+		var v interface{}
+		if s == "" {
+			s = "{}"
+		}
+		_ = json.Unmarshal([]byte(s), &v)
+		return v
+	}
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
+// subscriptionList return the subscriptions of the target to the topic, an unqualified function arn target the
+// function and all its aliases
+func subscriptionList(sess *session.Session, topicArn, targetArn string) ([]*sns.Subscription, error) {
+	var list []*sns.Subscription
+	err := snsClient(sess, topicArn).ListSubscriptionsByTopicPages(&sns.ListSubscriptionsByTopicInput{
+		TopicArn: aws.String(topicArn),
+	}, func(output *sns.ListSubscriptionsByTopicOutput, _ bool) bool {
+		for _, s := range output.Subscriptions {
+			if aws.StringValue(s.Protocol) == "lambda" && targetsFunction(aws.StringValue(s.Endpoint), targetArn) {
+				list = append(list, s)
+			}
+		}
+		return true
+	})
+	return list, err
+}
+
+// subscriptionDelete unsubscribe the target from the topic, an unqualified function arn target the function and all
+// its aliases
+func subscriptionDelete(sess *session.Session, topicArn, targetArn string) error {
+	subscriptions, err := subscriptionList(sess, topicArn, targetArn)
+	if err != nil {
+		return err
+	}
+	for _, s := range subscriptions {
+		_, err := snsClient(sess, topicArn).Unsubscribe(&sns.UnsubscribeInput{SubscriptionArn: s.SubscriptionArn})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscriptionTopics return the topics allowed to invoke the function or one of its aliases
+func subscriptionTopics(sess *session.Session, name string) ([]string, error) {
+	qualifiers, err := functionQualifiers(sess, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []string
+	seen := map[string]bool{}
+	for _, q := range qualifiers {
+		sources, err := permissionSources(sess, name, q, subscriptionStatementPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range sources {
+			if !seen[t] {
+				seen[t] = true
+				topics = append(topics, t)
+			}
+		}
+	}
+	return topics, nil
+}
+
+// subscriptionRemove unsubscribe the function and its aliases from every topic allowed to invoke them
+func subscriptionRemove(sess *session.Session, name, functionArn string) []Removal {
+	topics, err := subscriptionTopics(sess, name)
+	if err != nil {
+		return []Removal{newRemoval(fmt.Sprintf("subscriptions of %s", name), err)}
+	}
+
+	var removals []Removal
+	for _, t := range topics {
+		err := subscriptionDelete(sess, t, functionArn)
+		if errorCode(err) == sns.ErrCodeNotFoundException {
+			removals = append(removals, Removal{Resource: fmt.Sprintf("subscription to %s", t), Status: RemovalNotFound})
+			continue
+		}
+		removals = append(removals, newRemoval(fmt.Sprintf("subscription to %s", t), err))
+	}
+	return removals
+}
+
+// subscriptionTriggers return the subscriptions of the function and its aliases as triggers
+func subscriptionTriggers(sess *session.Session, name, functionArn string) ([]Trigger, error) {
+	topics, err := subscriptionTopics(sess, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Trigger
+	for _, t := range topics {
+		subscriptions, err := subscriptionList(sess, t, functionArn)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range subscriptions {
+			attributes, err := snsClient(sess, t).GetSubscriptionAttributes(&sns.GetSubscriptionAttributesInput{
+				SubscriptionArn: s.SubscriptionArn,
+			})
+			if err != nil {
+				return nil, err
+			}
+			details := ""
+			if policy := aws.StringValue(attributes.Attributes["FilterPolicy"]); policy != "" {
+				details = fmt.Sprintf("filter %s", policy)
+			}
+			list = append(list, Trigger{
+				Kind:    TriggerSns,
+				Source:  t,
+				Stage:   functionQualifier(aws.StringValue(s.Endpoint)),
+				State:   "Subscribed",
+				Details: details,
+			})
+		}
+	}
+	return list, nil
+}
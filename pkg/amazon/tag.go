@@ -0,0 +1,246 @@
+package amazon
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Tags set by awsl on every resource of a lambda
+const (
+	tagManager = "manager"
+	tagName    = "name"
+	tagId      = "id"
+	tagCreated = "created"
+
+	// tagRegion record on the role, which is global, the region of the lambda it was created for
+	tagRegion = "region"
+
+	managerAwsl = "awsl"
+
+	// tagManagedTags record on the function the user tags set by deploy, space separated, so the ones dropped from
+	// the manifest are removed while the ones added by the tag command are kept
+	tagManagedTags = "managed-tags"
+)
+
+// TagsCheck refuse the keys of the tags awsl relies on to find and manage the resources of a lambda, and the keys with
+// spaces which can't be recorded as managed by deploy
+func TagsCheck(keys []string) error {
+	for _, k := range keys {
+		switch k {
+		case tagManager, tagName, tagId, tagCreated, tagRegion, tagRoleOwner, tagManagedTags:
+			return fmt.Errorf("tag %s is managed by awsl and can't be changed", k)
+		}
+		if strings.ContainsAny(k, " \t") {
+			return fmt.Errorf("invalid tag %q, spaces are not allowed in keys", k)
+		}
+	}
+	return nil
+}
+
+// TagsReconcile apply the tags of the lambda to every resource and remove the user tags set by a previous deploy that
+// are no longer wanted. The function is the one found before the deploy, nil when it was just created.
+func TagsReconcile(sess *session.Session, name, id string, function *lambda.GetFunctionOutput, user map[string]string) error {
+	add := LambdaTags(name, id, function == nil, user)
+
+	var keys []string
+	for k := range user {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var remove []string
+	if function != nil {
+		for _, k := range strings.Fields(aws.StringValue(function.Tags[tagManagedTags])) {
+			if _, ok := user[k]; !ok {
+				remove = append(remove, k)
+			}
+		}
+	}
+	if len(keys) > 0 {
+		add[tagManagedTags] = strings.Join(keys, " ")
+	} else if function != nil && function.Tags[tagManagedTags] != nil {
+		remove = append(remove, tagManagedTags)
+	}
+
+	return TagsApply(sess, fmt.Sprintf("%s-%s", name, id), add, remove)
+}
+
+// LambdaTags return the tags of every resource of the lambda, the creation time is only given on creation so it is
+// never overwritten afterward
+func LambdaTags(name, id string, created bool, user map[string]string) map[string]string {
+	tags := map[string]string{}
+	for k, v := range user {
+		tags[k] = v
+	}
+	tags[tagManager] = managerAwsl
+	tags[tagName] = name
+	tags[tagId] = id
+	if created {
+		tags[tagCreated] = fmt.Sprintf("%d", time.Now().Unix())
+	}
+	return tags
+}
+
+// TagsApply add the tags and remove the keys on the function, the bucket, the role (unless it is external), the
+// schedule rules and the rest api of the lambda. Missing resources are skipped.
+func TagsApply(sess *session.Session, name string, add map[string]string, remove []string) error {
+	account, err := AccountGet(sess)
+	if err != nil {
+		return err
+	}
+
+	function := LambdaGet(sess, name)
+	if function != nil {
+		if err := lambdaTag(sess, *function.Configuration.FunctionArn, add, remove); err != nil {
+			return err
+		}
+	}
+
+	if S3BucketExist(sess, name) {
+		if err := bucketTag(sess, name, add, remove); err != nil {
+			return err
+		}
+	}
+
+	if function == nil || LambdaOwnRole(function) {
+		if err := roleTag(sess, name, add, remove); err != nil && errorCode(err) != iam.ErrCodeNoSuchEntityException {
+			return err
+		}
+	}
+
+	rules, err := scheduleRules(sess, name)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := ruleTag(sess, *rule.Arn, add, remove); err != nil {
+			return err
+		}
+	}
+
+	api, err := GatewayGet(sess, name)
+	if err != nil {
+		return err
+	}
+	if api != nil {
+		if err := restApiTag(sess, account.RestApiArn(*api.Id), add, remove); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lambdaTag(sess *session.Session, arn string, add map[string]string, remove []string) error {
+	l := lambda.New(sess)
+	if len(add) > 0 {
+		_, err := l.TagResource(&lambda.TagResourceInput{Resource: aws.String(arn), Tags: aws.StringMap(add)})
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		_, err := l.UntagResource(&lambda.UntagResourceInput{Resource: aws.String(arn), TagKeys: aws.StringSlice(remove)})
+		return err
+	}
+	return nil
+}
+
+// bucketTag merge the tags with the existing ones since s3 replace the whole tag set
+func bucketTag(sess *session.Session, bucket string, add map[string]string, remove []string) error {
+	s := s3.New(sess)
+
+	tags := map[string]string{}
+	output, err := s.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+	if err != nil && errorCode(err) != "NoSuchTagSet" {
+		return err
+	}
+	if output != nil {
+		for _, t := range output.TagSet {
+			tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+		}
+	}
+	for k, v := range add {
+		tags[k] = v
+	}
+	for _, k := range remove {
+		delete(tags, k)
+	}
+
+	if len(tags) == 0 {
+		_, err := s.DeleteBucketTagging(&s3.DeleteBucketTaggingInput{Bucket: aws.String(bucket)})
+		return err
+	}
+
+	var tagSet []*s3.Tag
+	for k, v := range tags {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err = s.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucket),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+func roleTag(sess *session.Session, role string, add map[string]string, remove []string) error {
+	i := iam.New(sess)
+	if len(add) > 0 {
+		var tags []*iam.Tag
+		for k, v := range add {
+			tags = append(tags, &iam.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		_, err := i.TagRole(&iam.TagRoleInput{RoleName: aws.String(role), Tags: tags})
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		_, err := i.UntagRole(&iam.UntagRoleInput{RoleName: aws.String(role), TagKeys: aws.StringSlice(remove)})
+		return err
+	}
+	return nil
+}
+
+func restApiTag(sess *session.Session, arn string, add map[string]string, remove []string) error {
+	gateway := apigateway.New(sess)
+	if len(add) > 0 {
+		_, err := gateway.TagResource(&apigateway.TagResourceInput{ResourceArn: aws.String(arn), Tags: aws.StringMap(add)})
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		_, err := gateway.UntagResource(&apigateway.UntagResourceInput{ResourceArn: aws.String(arn), TagKeys: aws.StringSlice(remove)})
+		return err
+	}
+	return nil
+}
+
+func ruleTag(sess *session.Session, arn string, add map[string]string, remove []string) error {
+	events := cloudwatchevents.New(sess)
+	if len(add) > 0 {
+		var tags []*cloudwatchevents.Tag
+		for k, v := range add {
+			tags = append(tags, &cloudwatchevents.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		_, err := events.TagResource(&cloudwatchevents.TagResourceInput{ResourceARN: aws.String(arn), Tags: tags})
+		if err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		_, err := events.UntagResource(&cloudwatchevents.UntagResourceInput{ResourceARN: aws.String(arn), TagKeys: aws.StringSlice(remove)})
+		return err
+	}
+	return nil
+}
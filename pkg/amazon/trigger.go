@@ -0,0 +1,468 @@
+package amazon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// triggersPolicyPrefix start the inline policies of the execution role allowing the lambda to read the event sources of
+// a stage, each stage has its own so deploying a stage never revokes the sources of the others
+const triggersPolicyPrefix = "awsl-triggers-"
+
+func triggersPolicyName(stage string) string {
+	return triggersPolicyPrefix + stage
+}
+
+type policyStatement struct {
+	Effect   string
+	Action   []string
+	Resource []string
+}
+
+// triggersPolicy return the policy document allowing the execution role to read the event sources of the stage of the
+// manifest, empty when it has none
+func triggersPolicy(account *Account, m *manifest.Manifest) (string, error) {
+	var statements []policyStatement
+
+	if m.Triggers != nil {
+		var queues []string
+		for _, q := range m.Triggers.Sqs {
+			queues = append(queues, account.QueueArn(q.Queue))
+		}
+		if len(queues) > 0 {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"sqs:ReceiveMessage", "sqs:DeleteMessage", "sqs:GetQueueAttributes", "sqs:ChangeMessageVisibility"},
+				Resource: queues,
+			})
+		}
+
+		var dynamodbStreams, kinesisStreams, queueDestinations, topicDestinations []string
+		for _, st := range m.Triggers.Streams {
+			if strings.Contains(st.Arn, ":dynamodb:") {
+				dynamodbStreams = append(dynamodbStreams, st.Arn)
+			} else {
+				kinesisStreams = append(kinesisStreams, st.Arn)
+			}
+			switch {
+			case strings.Contains(st.OnFailure, ":sqs:"):
+				queueDestinations = append(queueDestinations, st.OnFailure)
+			case strings.Contains(st.OnFailure, ":sns:"):
+				topicDestinations = append(topicDestinations, st.OnFailure)
+			}
+		}
+		if len(dynamodbStreams) > 0 {
+			statements = append(statements, policyStatement{
+				Effect:   "Allow",
+				Action:   []string{"dynamodb:DescribeStream", "dynamodb:GetRecords", "dynamodb:GetShardIterator", "dynamodb:ListStreams"},
+				Resource: dynamodbStreams,
+			})
+		}
+		if len(kinesisStreams) > 0 {
+			statements = append(statements, policyStatement{
+				Effect: "Allow",
+				Action: []string{"kinesis:DescribeStream", "kinesis:DescribeStreamSummary", "kinesis:GetRecords",
+					"kinesis:GetShardIterator", "kinesis:ListShards", "kinesis:ListStreams", "kinesis:SubscribeToShard"},
+				Resource: kinesisStreams,
+			})
+		}
+		if len(queueDestinations) > 0 {
+			statements = append(statements, policyStatement{Effect: "Allow", Action: []string{"sqs:SendMessage"}, Resource: queueDestinations})
+		}
+		if len(topicDestinations) > 0 {
+			statements = append(statements, policyStatement{Effect: "Allow", Action: []string{"sns:Publish"}, Resource: topicDestinations})
+		}
+	}
+
+	return policyDocument(statements)
+}
+
+// policyDocument return the document of a policy made of the statements, empty when there is none
+func policyDocument(statements []policyStatement) (string, error) {
+	if len(statements) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(map[string]interface{}{"Version": "2012-10-17", "Statement": statements})
+	return string(b), err
+}
+
+// eventSources return the event source mappings of the alias wanted by the manifest
+func eventSources(account *Account, aliasArn string, m *manifest.Manifest) []*lambda.CreateEventSourceMappingInput {
+	var list []*lambda.CreateEventSourceMappingInput
+	if m.Triggers == nil {
+		return list
+	}
+
+	for _, q := range m.Triggers.Sqs {
+		input := &lambda.CreateEventSourceMappingInput{
+			BatchSize:                      aws.Int64(q.BatchSize),
+			EventSourceArn:                 aws.String(account.QueueArn(q.Queue)),
+			FunctionName:                   aws.String(aliasArn),
+			MaximumBatchingWindowInSeconds: aws.Int64(q.BatchingWindow),
+		}
+		if q.MaxConcurrency != 0 {
+			input.ScalingConfig = &lambda.ScalingConfig{MaximumConcurrency: aws.Int64(q.MaxConcurrency)}
+		}
+		if q.PartialBatchResponse {
+			input.FunctionResponseTypes = aws.StringSlice([]string{lambda.FunctionResponseTypeReportBatchItemFailures})
+		}
+		list = append(list, input)
+	}
+
+	for _, st := range m.Triggers.Streams {
+		input := &lambda.CreateEventSourceMappingInput{
+			BatchSize:                  aws.Int64(st.BatchSize),
+			BisectBatchOnFunctionError: aws.Bool(st.BisectOnError),
+			EventSourceArn:             aws.String(st.Arn),
+			FunctionName:               aws.String(aliasArn),
+			MaximumRecordAgeInSeconds:  aws.Int64(st.MaxRecordAge),
+			MaximumRetryAttempts:       st.RetryAttempts,
+			StartingPosition:           aws.String(st.StartingPosition),
+		}
+		if st.OnFailure != "" {
+			input.DestinationConfig = &lambda.DestinationConfig{OnFailure: &lambda.OnFailure{Destination: aws.String(st.OnFailure)}}
+		}
+		list = append(list, input)
+	}
+	return list
+}
+
+// TriggersReconcile create, update or delete the event source mappings, the bucket notifications and the topic
+// subscriptions of the alias of the stage so they match the triggers of the manifest. Created ones are recorded in the
+// journal.
+func TriggersReconcile(ctx context.Context, sess *session.Session, journal *util.Journal, name, stage string, m *manifest.Manifest) error {
+	account, err := AccountGet(sess)
+	if err != nil {
+		return err
+	}
+
+	l := lambda.New(sess)
+
+	alias, err := l.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(name),
+		Name:         aws.String(stage),
+	})
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]*lambda.CreateEventSourceMappingInput{}
+	list := eventSources(account, *alias.AliasArn, m)
+	for _, w := range list {
+		wanted[*w.EventSourceArn] = w
+	}
+
+	var existing []*lambda.EventSourceMappingConfiguration
+	err = l.ListEventSourceMappingsPages(&lambda.ListEventSourceMappingsInput{
+		FunctionName: alias.AliasArn,
+	}, func(output *lambda.ListEventSourceMappingsOutput, _ bool) bool {
+		existing = append(existing, output.EventSourceMappings...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	found := map[string]bool{}
+	for _, e := range existing {
+		if aws.StringValue(e.State) == "Deleting" {
+			continue
+		}
+		w, ok := wanted[aws.StringValue(e.EventSourceArn)]
+		if !ok {
+			if _, err := l.DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{UUID: e.UUID}); err != nil {
+				return err
+			}
+			continue
+		}
+		found[*w.EventSourceArn] = true
+		if !eventSourceChanged(e, w) {
+			continue
+		}
+		if _, err := l.UpdateEventSourceMapping(eventSourceUpdate(e.UUID, w)); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range list {
+		if found[*w.EventSourceArn] {
+			continue
+		}
+		var output *lambda.EventSourceMappingConfiguration
+		err := util.NewBackoff(ctx, "create event source mapping", func() error {
+			output, err = l.CreateEventSourceMapping(w)
+			return err
+		}).WithRetryable(permissionsNotReady).WithOnRetry(util.ActionRetry).Execute()
+		if err != nil {
+			return err
+		}
+		journal.Record(fmt.Sprintf("event source mapping of %s", *w.EventSourceArn), func() error {
+			_, err := l.DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{UUID: output.UUID})
+			return err
+		})
+	}
+
+	if err := notificationsReconcile(sess, journal, name, stage, *alias.AliasArn, m); err != nil {
+		return err
+	}
+	return subscriptionsReconcile(sess, journal, name, stage, *alias.AliasArn, m)
+}
+
+// eventSourceChanged tell if the settings of an existing mapping differ from the wanted ones
+func eventSourceChanged(e *lambda.EventSourceMappingConfiguration, w *lambda.CreateEventSourceMappingInput) bool {
+	maxConcurrency := func(c *lambda.ScalingConfig) int64 {
+		if c == nil {
+			return 0
+		}
+		return aws.Int64Value(c.MaximumConcurrency)
+	}
+
+	onFailure := func(c *lambda.DestinationConfig) string {
+		if c == nil || c.OnFailure == nil {
+			return ""
+		}
+		return aws.StringValue(c.OnFailure.Destination)
+	}
+
+	changed := aws.Int64Value(e.BatchSize) != aws.Int64Value(w.BatchSize) ||
+		aws.Int64Value(e.MaximumBatchingWindowInSeconds) != aws.Int64Value(w.MaximumBatchingWindowInSeconds) ||
+		len(e.FunctionResponseTypes) != len(w.FunctionResponseTypes)
+	if w.StartingPosition == nil {
+		return changed || maxConcurrency(e.ScalingConfig) != maxConcurrency(w.ScalingConfig)
+	}
+	return changed || aws.BoolValue(e.BisectBatchOnFunctionError) != aws.BoolValue(w.BisectBatchOnFunctionError) ||
+		aws.Int64Value(e.MaximumRetryAttempts) != aws.Int64Value(w.MaximumRetryAttempts) ||
+		aws.Int64Value(e.MaximumRecordAgeInSeconds) != aws.Int64Value(w.MaximumRecordAgeInSeconds) ||
+		onFailure(e.DestinationConfig) != onFailure(w.DestinationConfig)
+}
+
+// eventSourceUpdate is the update giving the wanted settings to an existing mapping, unset settings are cleared. The
+// starting position of a stream can't be changed.
+func eventSourceUpdate(uuid *string, w *lambda.CreateEventSourceMappingInput) *lambda.UpdateEventSourceMappingInput {
+	input := &lambda.UpdateEventSourceMappingInput{
+		BatchSize:                      w.BatchSize,
+		FunctionResponseTypes:          w.FunctionResponseTypes,
+		MaximumBatchingWindowInSeconds: w.MaximumBatchingWindowInSeconds,
+		UUID:                           uuid,
+	}
+	if input.FunctionResponseTypes == nil {
+		input.FunctionResponseTypes = []*string{}
+	}
+
+	// Queues and streams don't accept the same settings
+	if w.StartingPosition == nil {
+		input.ScalingConfig = w.ScalingConfig
+		if input.ScalingConfig == nil {
+			input.ScalingConfig = &lambda.ScalingConfig{}
+		}
+		return input
+	}
+	input.BisectBatchOnFunctionError = w.BisectBatchOnFunctionError
+	input.DestinationConfig = w.DestinationConfig
+	input.MaximumRecordAgeInSeconds = w.MaximumRecordAgeInSeconds
+	input.MaximumRetryAttempts = w.MaximumRetryAttempts
+	if input.DestinationConfig == nil {
+		input.DestinationConfig = &lambda.DestinationConfig{OnFailure: &lambda.OnFailure{}}
+	}
+	return input
+}
+
+// eventSourceList return the event source mappings of every version and alias of the function
+func eventSourceList(sess *session.Session, functionArn string) ([]*lambda.EventSourceMappingConfiguration, error) {
+	var mappings []*lambda.EventSourceMappingConfiguration
+	err := lambda.New(sess).ListEventSourceMappingsPages(&lambda.ListEventSourceMappingsInput{}, func(output *lambda.ListEventSourceMappingsOutput, _ bool) bool {
+		for _, e := range output.EventSourceMappings {
+			if unqualifiedFunctionArn(aws.StringValue(e.FunctionArn)) == functionArn {
+				mappings = append(mappings, e)
+			}
+		}
+		return true
+	})
+	return mappings, err
+}
+
+// eventSourceRemove delete the event source mappings of every version and alias of the function
+func eventSourceRemove(sess *session.Session, functionArn string) []Removal {
+	var removals []Removal
+
+	mappings, err := eventSourceList(sess, functionArn)
+	if err != nil {
+		return append(removals, newRemoval(fmt.Sprintf("event source mappings of %s", functionArn), err))
+	}
+
+	for _, e := range mappings {
+		_, err := lambda.New(sess).DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{UUID: e.UUID})
+		removals = append(removals, newRemoval(fmt.Sprintf("event source mapping of %s", aws.StringValue(e.EventSourceArn)), err))
+	}
+	return removals
+}
+
+// Kinds of trigger
+const (
+	TriggerSchedule = "schedule"
+	TriggerSqs      = "sqs"
+	TriggerDynamodb = "dynamodb"
+	TriggerKinesis  = "kinesis"
+	TriggerS3       = "s3"
+	TriggerSns      = "sns"
+)
+
+// Trigger is an event source invoking the lambda, the stage is the alias it invokes
+type Trigger struct {
+	Kind    string `json:"kind"`
+	Source  string `json:"source"`
+	Stage   string `json:"stage"`
+	State   string `json:"state"`
+	Details string `json:"details"`
+}
+
+// TriggersList return the schedule, the event source mappings, the bucket notifications and the topic subscriptions
+// invoking the lambda
+func TriggersList(sess *session.Session, name string) ([]Trigger, error) {
+	function := LambdaGet(sess, name)
+	if function == nil {
+		return nil, fmt.Errorf("lambda %s not found", name)
+	}
+	functionArn := *function.Configuration.FunctionArn
+
+	list, err := scheduleTriggers(sess, name)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := eventSourceList(sess, functionArn)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range mappings {
+		list = append(list, eventSourceTrigger(e))
+	}
+
+	notifications, err := notificationTriggers(sess, name, functionArn)
+	if err != nil {
+		return nil, err
+	}
+	list = append(list, notifications...)
+
+	subscriptions, err := subscriptionTriggers(sess, name, functionArn)
+	if err != nil {
+		return nil, err
+	}
+	return append(list, subscriptions...), nil
+}
+
+func eventSourceTrigger(e *lambda.EventSourceMappingConfiguration) Trigger {
+	source := aws.StringValue(e.EventSourceArn)
+	t := Trigger{
+		Kind:   TriggerSqs,
+		Source: source,
+		Stage:  functionQualifier(aws.StringValue(e.FunctionArn)),
+		State:  aws.StringValue(e.State),
+	}
+
+	details := []string{fmt.Sprintf("batch %d", aws.Int64Value(e.BatchSize))}
+	if w := aws.Int64Value(e.MaximumBatchingWindowInSeconds); w > 0 {
+		details = append(details, fmt.Sprintf("window %ds", w))
+	}
+	if e.ScalingConfig != nil && e.ScalingConfig.MaximumConcurrency != nil {
+		details = append(details, fmt.Sprintf("concurrency %d", *e.ScalingConfig.MaximumConcurrency))
+	}
+	if len(e.FunctionResponseTypes) > 0 {
+		details = append(details, "partial batch response")
+	}
+
+	if e.StartingPosition != nil {
+		t.Kind = TriggerKinesis
+		if strings.Contains(source, ":dynamodb:") {
+			t.Kind = TriggerDynamodb
+		}
+		details = append(details, fmt.Sprintf("from %s", aws.StringValue(e.StartingPosition)))
+		if aws.BoolValue(e.BisectBatchOnFunctionError) {
+			details = append(details, "bisect on error")
+		}
+		details = append(details, fmt.Sprintf("retries %d", aws.Int64Value(e.MaximumRetryAttempts)))
+		if age := aws.Int64Value(e.MaximumRecordAgeInSeconds); age > 0 {
+			details = append(details, fmt.Sprintf("max age %ds", age))
+		}
+		if e.DestinationConfig != nil && e.DestinationConfig.OnFailure != nil && e.DestinationConfig.OnFailure.Destination != nil {
+			details = append(details, fmt.Sprintf("on failure %s", *e.DestinationConfig.OnFailure.Destination))
+		}
+	}
+	t.Details = strings.Join(details, ", ")
+	return t
+}
+
+// functionQualifier return the version or alias of a function arn, empty when it is unqualified
+func functionQualifier(arn string) string {
+	split := strings.Split(arn, ":")
+	if len(split) > 7 {
+		return split[7]
+	}
+	return ""
+}
+
+// targetsFunction tell if the function arn is the target, an unqualified target stands for the function and all its
+// versions and aliases
+func targetsFunction(arn, targetArn string) bool {
+	return arn == targetArn || (functionQualifier(targetArn) == "" && unqualifiedFunctionArn(arn) == targetArn)
+}
+
+// functionQualifiers return the aliases of the function preceded by an empty qualifier for the function itself
+func functionQualifiers(sess *session.Session, name string) ([]string, error) {
+	qualifiers := []string{""}
+	err := lambda.New(sess).ListAliasesPages(&lambda.ListAliasesInput{
+		FunctionName: aws.String(name),
+	}, func(output *lambda.ListAliasesOutput, _ bool) bool {
+		for _, a := range output.Aliases {
+			qualifiers = append(qualifiers, aws.StringValue(a.Name))
+		}
+		return true
+	})
+	return qualifiers, err
+}
+
+// permissionSources return the source arns of the statements of the resource policy of the function, or of one of its
+// aliases, whose id start with the prefix
+func permissionSources(sess *session.Session, name, qualifier, statementPrefix string) ([]string, error) {
+	input := &lambda.GetPolicyInput{FunctionName: aws.String(name)}
+	if qualifier != "" {
+		input.Qualifier = aws.String(qualifier)
+	}
+	output, err := lambda.New(sess).GetPolicy(input)
+	if errorCode(err) == lambda.ErrCodeResourceNotFoundException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var policy struct {
+		Statement []struct {
+			Sid       string
+			Condition map[string]map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(output.Policy)), &policy); err != nil {
+		return nil, err
+	}
+
+	var sources []string
+	for _, s := range policy.Statement {
+		if !strings.HasPrefix(s.Sid, statementPrefix) {
+			continue
+		}
+		if arn, ok := s.Condition["ArnLike"]["AWS:SourceArn"]; ok {
+			sources = append(sources, arn)
+		}
+	}
+	return sources, nil
+}
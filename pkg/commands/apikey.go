@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/spf13/cobra"
+)
+
+// flApiKeyRate is the number of requests per second allowed by the usage plan
+var flApiKeyRate float64
+
+// flApiKeyBurst is the number of concurrent requests allowed by the usage plan
+var flApiKeyBurst int64
+
+// flApiKeyQuota is the number of requests allowed per period by the usage plan, 0 means no quota
+var flApiKeyQuota int64
+
+// flApiKeyPeriod is the period of the quota: DAY, WEEK or MONTH
+var flApiKeyPeriod string
+
+func apiKeyCreate(cmd *cobra.Command, args []string) error {
+	// Only the limits given change an existing usage plan
+	plan := amazon.UsagePlan{}
+	flags := cmd.Flags()
+	if flags.Changed("rate") {
+		plan.Rate = &flApiKeyRate
+	}
+	if flags.Changed("burst") {
+		plan.Burst = &flApiKeyBurst
+	}
+	if flags.Changed("quota") {
+		plan.Quota = &flApiKeyQuota
+	}
+	if flags.Changed("period") {
+		plan.Period = &flApiKeyPeriod
+	}
+
+	var key *apigateway.ApiKey
+	if err := util.Action("Creating api key", func() error {
+		var err error
+		key, err = amazon.ApiKeyCreate(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]), plan)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("Api key id   ", *key.Id)
+	fmt.Println("Api key value", *key.Value)
+	fmt.Println("The value will not be shown again, send it in the x-api-key header")
+	return nil
+}
+
+func apiKeyList(_ *cobra.Command, args []string) error {
+	keys, err := amazon.ApiKeyList(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]))
+	if err != nil {
+		return err
+	}
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "ID\tNAME\tENABLED\tCREATED AT\t\n")
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%t\t%s\t\n", *k.Id, *k.Name, aws.BoolValue(k.Enabled), aws.TimeValue(k.CreatedDate).Format(time.RFC822))
+	}
+	_ = tab.Flush()
+	return nil
+}
+
+func apiKeyRevoke(_ *cobra.Command, args []string) error {
+	return util.Action(fmt.Sprintf("Revoking api key %s", args[2]), func() error {
+		return amazon.ApiKeyRevoke(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]), args[2])
+	})
+}
+
+func init() {
+	cmdApiKey := &cobra.Command{
+		Use:   "apikey",
+		Short: "Manage api keys of a lambda deployed with --auth apikey",
+	}
+
+	cmdApiKeyCreate := &cobra.Command{
+		Use:   "create <name> <id>",
+		Short: "Create an api key for a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  apiKeyCreate,
+	}
+	cmdApiKeyCreate.PersistentFlags().Float64Var(&flApiKeyRate, "rate", 10, "number of requests per second allowed by the usage plan, 10 on creation, the current one is kept when not given")
+	cmdApiKeyCreate.PersistentFlags().Int64Var(&flApiKeyBurst, "burst", 20, "number of concurrent requests allowed by the usage plan, 20 on creation, the current one is kept when not given")
+	cmdApiKeyCreate.PersistentFlags().Int64Var(&flApiKeyQuota, "quota", 0, "number of requests allowed per period by the usage plan, 0 removes the quota")
+	cmdApiKeyCreate.PersistentFlags().StringVar(&flApiKeyPeriod, "period", "MONTH", "period of the quota: DAY, WEEK or MONTH")
+
+	cmdApiKeyList := &cobra.Command{
+		Use:   "list <name> <id>",
+		Short: "List api keys of a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  apiKeyList,
+	}
+
+	cmdApiKeyRevoke := &cobra.Command{
+		Use:   "revoke <name> <id> <key id>",
+		Short: "Revoke an api key of a lambda",
+		Args:  cobra.ExactArgs(3),
+		RunE:  apiKeyRevoke,
+	}
+
+	cmdApiKey.AddCommand(cmdApiKeyCreate, cmdApiKeyList, cmdApiKeyRevoke)
+	Root.AddCommand(cmdApiKey)
+}
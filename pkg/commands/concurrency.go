@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/spf13/cobra"
+)
+
+// flConcurrencyReserved cap the concurrent executions of the function, -1 remove the cap
+var flConcurrencyReserved int64
+
+// flConcurrencyProvisioned keep instances of the alias of each stage initialized, 0 remove them
+var flConcurrencyProvisioned map[string]int
+
+func concurrency(cmd *cobra.Command, args []string) error {
+	resourceName := fmt.Sprintf("%s-%s", args[0], args[1])
+
+	if cmd.Flags().Changed("reserved") {
+		if flConcurrencyReserved < -1 {
+			return fmt.Errorf("invalid reserved concurrency %d, must be positive or -1 to remove the cap", flConcurrencyReserved)
+		}
+		var reserved *int64
+		if flConcurrencyReserved >= 0 {
+			reserved = &flConcurrencyReserved
+		}
+		if err := util.Action("Setting the reserved concurrency of your lambda", func() error {
+			return amazon.ReservedConcurrencyPut(awsSession, resourceName, reserved)
+		}); err != nil {
+			return err
+		}
+	}
+
+	var stages []string
+	for stage := range flConcurrencyProvisioned {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		n := int64(flConcurrencyProvisioned[stage])
+		if n < 0 {
+			return fmt.Errorf("invalid provisioned concurrency %d of stage %s, must be positive", n, stage)
+		}
+		if err := util.Action(fmt.Sprintf("Provisioning %d instances of stage %s", n, stage), func() error {
+			return amazon.ProvisionedConcurrencyPut(awsContext, awsSession, resourceName, stage, n)
+		}); err != nil {
+			return err
+		}
+	}
+
+	c, err := amazon.ConcurrencyGet(awsSession, resourceName)
+	if err != nil {
+		return err
+	}
+
+	reserved := "none"
+	if c.Reserved != nil {
+		reserved = fmt.Sprintf("%d", *c.Reserved)
+	}
+	fmt.Printf("Reserved concurrency: %s\n\n", reserved)
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "STAGE\tREQUESTED\tALLOCATED\tSTATUS\t\n")
+	for _, p := range c.Provisioned {
+		_, _ = fmt.Fprintf(tab, "%s\t%d\t%d\t%s\t\n", p.Stage, p.Requested, p.Allocated, p.Status)
+	}
+	return tab.Flush()
+}
+
+func init() {
+	cmdConcurrency := &cobra.Command{
+		Use:   "concurrency <name> <id>",
+		Short: "Show or set the reserved and provisioned concurrency of a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  concurrency,
+	}
+
+	cmdConcurrency.PersistentFlags().Int64Var(&flConcurrencyReserved, "reserved", -1, "cap the concurrent executions of the function, -1 remove the cap")
+	cmdConcurrency.PersistentFlags().StringToIntVar(&flConcurrencyProvisioned, "provisioned", nil, "keep instances of the alias of a stage initialized, as stage=N, 0 remove them")
+
+	Root.AddCommand(cmdConcurrency)
+}
@@ -1,13 +1,17 @@
 package commands
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 
 	"aws-test/pkg/amazon"
+	"aws-test/pkg/manifest"
 	"aws-test/pkg/util"
 
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/spf13/cobra"
 )
 
@@ -20,10 +24,145 @@ var flDeployId string
 // flDeployRuntime set the runtime (the programming language) of the function
 var flDeployRuntime string
 
-func deploy(_ *cobra.Command, args []string) error {
+// flDeployAuth set the authentication required to call the api
+var flDeployAuth string
+
+// flDeployStage set the stage on which the lambda is deployed
+var flDeployStage string
+
+// flDeployRole set an existing role, given by its name or arn, as the execution role instead of creating one
+var flDeployRole string
+
+// flDeployKeepOnFailure keep the resources created by a failed deploy instead of removing them
+var flDeployKeepOnFailure bool
+
+// flDeployTags set tags on every resource of the lambda, added to the ones of the manifest
+var flDeployTags map[string]string
+
+// flDeploySchedule invoke the lambda on a rate(...) or cron(...) schedule expression, none remove the schedule
+var flDeploySchedule string
+
+// flDeployScheduleInput set the constant json given to the lambda on each scheduled invocation
+var flDeployScheduleInput string
+
+// flDeployReservedConcurrency cap the concurrent executions of the function, -1 remove the cap
+var flDeployReservedConcurrency int64
+
+// flDeployProvisionedConcurrency keep instances of the deployed stage initialized
+var flDeployProvisionedConcurrency int64
+
+// flDeployCorsOrigins set the origins allowed to call the api
+var flDeployCorsOrigins []string
+
+// flDeployCorsMethods set the methods allowed by cors
+var flDeployCorsMethods []string
+
+// flDeployCorsHeaders set the headers allowed by cors
+var flDeployCorsHeaders []string
+
+// flDeployCorsMaxAge set how long, in seconds, a preflight response can be cached
+var flDeployCorsMaxAge int64
+
+// flDeployCorsCredentials allow credentials to be sent by browsers
+var flDeployCorsCredentials bool
+
+// deployManifest override the manifest with the flags given to the command
+func deployManifest(cmd *cobra.Command) (*manifest.Manifest, error) {
+	m := lambdaManifest
+
+	flags := cmd.Flags()
+	if flags.Changed("runtime") || m.Runtime == "" {
+		m.Runtime = flDeployRuntime
+	}
+
+	if flags.Changed("stage") || m.Stage == "" {
+		m.Stage = flDeployStage
+	}
+
+	if flags.Changed("role") {
+		m.Role = flDeployRole
+	}
+
+	if flags.Changed("tag") {
+		if m.Tags == nil {
+			m.Tags = map[string]string{}
+		}
+		for k, v := range flDeployTags {
+			m.Tags[k] = v
+		}
+	}
+
+	if flags.Changed("schedule") {
+		m.Schedule = flDeploySchedule
+	}
+
+	if flags.Changed("schedule-input") {
+		m.ScheduleInput = flDeployScheduleInput
+	}
+
+	if flags.Changed("reserved-concurrency") || flags.Changed("provisioned-concurrency") {
+		if m.Concurrency == nil {
+			m.Concurrency = &manifest.Concurrency{}
+		}
+		if flags.Changed("reserved-concurrency") {
+			m.Concurrency.Reserved = &flDeployReservedConcurrency
+		}
+		if flags.Changed("provisioned-concurrency") {
+			if m.Concurrency.Provisioned == nil {
+				m.Concurrency.Provisioned = map[string]int64{}
+			}
+			m.Concurrency.Provisioned[m.Stage] = flDeployProvisionedConcurrency
+		}
+	}
+
+	if flags.Changed("auth") {
+		m.Auth = flDeployAuth
+	}
+
+	if flags.Changed("cors-origin") || flags.Changed("cors-method") || flags.Changed("cors-header") ||
+		flags.Changed("cors-max-age") || flags.Changed("cors-credentials") {
+		if m.Cors == nil {
+			m.Cors = &manifest.Cors{}
+		}
+		if flags.Changed("cors-origin") {
+			m.Cors.Origins = flDeployCorsOrigins
+		}
+		if flags.Changed("cors-method") {
+			m.Cors.Methods = flDeployCorsMethods
+		}
+		if flags.Changed("cors-header") {
+			m.Cors.Headers = flDeployCorsHeaders
+		}
+		if flags.Changed("cors-max-age") {
+			m.Cors.MaxAge = flDeployCorsMaxAge
+		}
+		if flags.Changed("cors-credentials") {
+			m.Cors.Credentials = flDeployCorsCredentials
+		}
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	if err := amazon.TagsCheck(keys); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func deploy(cmd *cobra.Command, args []string) error {
 	name := args[0]
 	folder := args[1]
 
+	m, err := deployManifest(cmd)
+	if err != nil {
+		return err
+	}
+
 	fmt.Println(folder)
 
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
@@ -37,6 +176,22 @@ func deploy(_ *cobra.Command, args []string) error {
 		lambdaCtx.id = flDeployId
 	}
 
+	journal := &util.Journal{}
+	link, err := deployLambda(lambdaCtx, m, journal)
+	if err != nil {
+		return deployFailed(lambdaCtx, journal, err)
+	}
+
+	fmt.Println("Lambda id   ", lambdaCtx.id)
+	if link != nil {
+		fmt.Println("Lambda public link ", *link)
+	}
+
+	return nil
+}
+
+// deployLambda create or update the lambda, resources created are recorded in the journal
+func deployLambda(lambdaCtx lambdaCtx, m *manifest.Manifest, journal *util.Journal) (*string, error) {
 	var (
 		sum, s3key string
 		file       *os.File
@@ -46,13 +201,27 @@ func deploy(_ *cobra.Command, args []string) error {
 
 	resourceName := fmt.Sprintf("%s-%s", lambdaCtx.name, lambdaCtx.id)
 
+	// Check the existing role before anything is created
+	var role *iam.Role
+	if m.Role != "" {
+		if err := util.Action(fmt.Sprintf("Checking role %s can be assumed by lambda", m.Role), func() error {
+			role, err = amazon.RoleValidate(awsSession, m.Role)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+
 	// Bucket creation to store code
 	if !amazon.S3BucketExist(awsSession, resourceName) {
 		if err := util.Action(fmt.Sprintf("Creating bucket %s", resourceName), func() error {
 			return amazon.S3CreateBucket(awsSession, resourceName)
 		}); err != nil {
-			return err
+			return nil, err
 		}
+		journal.Record(fmt.Sprintf("bucket %s", resourceName), func() error {
+			return amazon.S3DeleteBucket(awsSession, resourceName)
+		})
 	}
 
 	// Create a local zip of the code in the folder
@@ -63,7 +232,7 @@ func deploy(_ *cobra.Command, args []string) error {
 		}
 		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Upload the code on the provider
@@ -77,33 +246,120 @@ func deploy(_ *cobra.Command, args []string) error {
 		}
 		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Create or Update the lambda
 	lambdaGet := amazon.LambdaGet(awsSession, resourceName)
 	if lambdaGet != nil {
+		var cfg *lambda.FunctionConfiguration
+		// Without a role the function keeps its current one
+		if role != nil {
+			if err := util.Action(fmt.Sprintf("Setting role %s as the execution role of your lambda", m.Role), func() error {
+				return amazon.LambdaRolePut(awsContext, awsSession, lambdaGet, role)
+			}); err != nil {
+				return nil, err
+			}
+		} else if amazon.LambdaOwnRole(lambdaGet) {
+			if err := util.Action(fmt.Sprintf("Reconciling the policies of your lambda"), func() error {
+				return amazon.RoleReconcile(awsSession, resourceName, m)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if err := util.Action(fmt.Sprintf("Setting the dead letter queue of your lambda"), func() error {
+			return amazon.LambdaDeadLetterPut(awsContext, awsSession, resourceName, m)
+		}); err != nil {
+			return nil, err
+		}
 		if err := util.Action(fmt.Sprintf("Updating code of your lambda"), func() error {
-			_, err := amazon.LambdaUpdateCode(awsSession, resourceName, s3key)
+			cfg, err = amazon.LambdaUpdateCode(awsSession, resourceName, s3key)
 			return err
 		}); err != nil {
+			return nil, err
+		}
+		var changed bool
+		if err := util.Action(fmt.Sprintf("Reconciling the api gateway of your lambda"), func() error {
+			changed, err = amazon.GatewayReconcile(awsSession, journal, resourceName, *cfg.FunctionArn, m)
 			return err
+		}); err != nil {
+			return nil, err
+		}
+		if err := util.Action(fmt.Sprintf("Deploying version %s on stage %s", *cfg.Version, m.Stage), func() error {
+			stageLink, err := amazon.StageDeploy(awsSession, resourceName, m.Stage, *cfg.Version, m.Stages[m.Stage], changed)
+			link = &stageLink
+			return err
+		}); err != nil {
+			return nil, err
 		}
 	} else {
 		if err := util.Action(fmt.Sprintf("Creating your lambda"), func() error {
-			link, err = amazon.LambdaCreate(awsSession, lambdaCtx.id, flDeployRuntime, resourceName, s3key)
+			link, err = amazon.LambdaCreate(awsContext, awsSession, journal, lambdaCtx.id, resourceName, s3key, m)
 			return err
 		}); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	fmt.Println("Lambda id   ", lambdaCtx.id)
-	if link != nil {
-		fmt.Println("Lambda public link ", *link)
+	if err := util.Action(fmt.Sprintf("Reconciling the schedule of your lambda"), func() error {
+		return amazon.ScheduleReconcile(awsSession, journal, resourceName, m.Stage, m)
+	}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	if err := util.Action(fmt.Sprintf("Reconciling the triggers of your lambda"), func() error {
+		return amazon.TriggersReconcile(awsContext, awsSession, journal, resourceName, m.Stage, m)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := util.Action(fmt.Sprintf("Configuring the asynchronous invocations of your lambda"), func() error {
+		return amazon.AsyncReconcile(awsContext, awsSession, resourceName, m.Stage, m)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := util.Action(fmt.Sprintf("Configuring the concurrency of your lambda"), func() error {
+		return amazon.ConcurrencyReconcile(awsContext, awsSession, journal, resourceName, m.Concurrency)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Tags are applied once every resource exists, the creation time is kept on update
+	if err := util.Action(fmt.Sprintf("Tagging the resources of your lambda"), func() error {
+		return amazon.TagsReconcile(awsSession, lambdaCtx.name, lambdaCtx.id, lambdaGet, m.Tags)
+	}); err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// deployFailed remove the resources created by the failed deploy, or keep them and explain how to resume it
+func deployFailed(lambdaCtx lambdaCtx, journal *util.Journal, err error) error {
+	created := journal.Descriptions()
+	if len(created) == 0 {
+		return err
+	}
+
+	if flDeployKeepOnFailure {
+		fmt.Println("Deploy failed, the resources already created are kept:")
+		for _, d := range created {
+			fmt.Println(" -", d)
+		}
+		fmt.Printf("Resume it with: awsl deploy %s %s --id %s --force\n\n", lambdaCtx.name, lambdaCtx.folder, lambdaCtx.id)
+		return err
+	}
+
+	// The removal must happen even when the deploy was interrupted
+	awsContext = context.Background()
+
+	fmt.Println("Deploy failed, removing the resources already created")
+	fmt.Println()
+	if rollbackErr := journal.Rollback(); rollbackErr != nil {
+		return fmt.Errorf("%v\n%v", err, rollbackErr)
+	}
+	return err
 }
 
 func init() {
@@ -116,6 +372,20 @@ func init() {
 	cmdDeploy.PersistentFlags().BoolVarP(&flDeployForce, "force", "f", false, "force deployment if code already exist")
 	cmdDeploy.PersistentFlags().StringVar(&flDeployId, "id", "", "set the id of the lambda, if none a new lambda will be created")
 	cmdDeploy.PersistentFlags().StringVarP(&flDeployRuntime, "runtime", "r", "go1.x", "set the runtime (the programming language) of the function")
+	cmdDeploy.PersistentFlags().BoolVar(&flDeployKeepOnFailure, "keep-on-failure", false, "keep the resources created by a failed deploy instead of removing them")
+	cmdDeploy.PersistentFlags().StringVar(&flDeployStage, "stage", amazon.GatewayStage, "set the stage on which the lambda is deployed")
+	cmdDeploy.PersistentFlags().StringVar(&flDeployRole, "role", "", "set an existing role, given by its name or arn, as the execution role instead of creating one, the current role is kept when not given")
+	cmdDeploy.PersistentFlags().StringToStringVar(&flDeployTags, "tag", nil, "set tags on every resource of the lambda, added to the ones of the manifest")
+	cmdDeploy.PersistentFlags().StringVar(&flDeploySchedule, "schedule", "", "invoke the lambda on a rate(...) or cron(...) schedule expression, none remove the schedule")
+	cmdDeploy.PersistentFlags().StringVar(&flDeployScheduleInput, "schedule-input", "", "set the constant json given to the lambda on each scheduled invocation")
+	cmdDeploy.PersistentFlags().Int64Var(&flDeployReservedConcurrency, "reserved-concurrency", 0, "cap the concurrent executions of the function, -1 remove the cap")
+	cmdDeploy.PersistentFlags().Int64Var(&flDeployProvisionedConcurrency, "provisioned-concurrency", 0, "keep instances of the deployed stage initialized, 0 removes them")
+	cmdDeploy.PersistentFlags().StringVar(&flDeployAuth, "auth", "", "set the authentication required to call the api: none, iam or apikey, the current one is kept when not given")
+	cmdDeploy.PersistentFlags().StringSliceVar(&flDeployCorsOrigins, "cors-origin", nil, "set the origins allowed to call the api")
+	cmdDeploy.PersistentFlags().StringSliceVar(&flDeployCorsMethods, "cors-method", nil, "set the methods allowed by cors")
+	cmdDeploy.PersistentFlags().StringSliceVar(&flDeployCorsHeaders, "cors-header", nil, "set the headers allowed by cors")
+	cmdDeploy.PersistentFlags().Int64Var(&flDeployCorsMaxAge, "cors-max-age", 0, "set how long, in seconds, a preflight response can be cached")
+	cmdDeploy.PersistentFlags().BoolVar(&flDeployCorsCredentials, "cors-credentials", false, "allow credentials to be sent by browsers")
 
 	Root.AddCommand(cmdDeploy)
 }
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/spf13/cobra"
+)
+
+// flDescribeStage is the stage whose asynchronous invocations are described
+var flDescribeStage string
+
+// flDescribeOutput set the format of the description: table or json
+var flDescribeOutput string
+
+func describe(_ *cobra.Command, args []string) error {
+	if flDescribeOutput != "table" && flDescribeOutput != "json" {
+		return fmt.Errorf("invalid output %q, must be table or json", flDescribeOutput)
+	}
+
+	d, err := amazon.LambdaDescribe(awsSession, args[0], args[1], flDescribeStage)
+	if err != nil {
+		return err
+	}
+
+	if flDescribeOutput == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(d)
+	}
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "NAME\t%s\t\n", d.Name)
+	_, _ = fmt.Fprintf(tab, "ID\t%s\t\n", d.Id)
+	_, _ = fmt.Fprintf(tab, "ARN\t%s\t\n", d.Arn)
+	_, _ = fmt.Fprintf(tab, "RUNTIME\t%s\t\n", d.Runtime)
+	_, _ = fmt.Fprintf(tab, "HANDLER\t%s\t\n", d.Handler)
+	_, _ = fmt.Fprintf(tab, "MEMORY\t%s\t\n", util.HumanByteSize(d.Memory*1000000))
+	_, _ = fmt.Fprintf(tab, "TIMEOUT\t%s\t\n", time.Duration(d.Timeout)*time.Second)
+	_, _ = fmt.Fprintf(tab, "ARCHITECTURES\t%s\t\n", orNone(strings.Join(d.Architectures, " ")))
+	_, _ = fmt.Fprintf(tab, "ENVIRONMENT\t%s\t\n", orNone(strings.Join(d.Environment, " ")))
+	_, _ = fmt.Fprintf(tab, "CODE SHA256\t%s\t\n", d.CodeSha256)
+	_, _ = fmt.Fprintf(tab, "CODE SIZE\t%s\t\n", util.HumanByteSize(d.CodeSize))
+	_, _ = fmt.Fprintf(tab, "ROLE\t%s (%s)\t\n", d.Role.Arn, d.Role.Owner)
+	_, _ = fmt.Fprintf(tab, "POLICIES\t%s\t\n", orNone(strings.Join(d.Role.Policies, " ")))
+	_, _ = fmt.Fprintf(tab, "INLINE POLICIES\t%s\t\n", orNone(strings.Join(d.Role.InlinePolicies, " ")))
+	_, _ = fmt.Fprintf(tab, "FUNCTION URLS\t%s\t\n", orNone(strings.Join(d.FunctionUrls, " ")))
+	var tags []string
+	for k, v := range d.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	_, _ = fmt.Fprintf(tab, "TAGS\t%s\t\n", orNone(strings.Join(tags, " ")))
+	if d.Async != nil {
+		_, _ = fmt.Fprintf(tab, "ASYNC RETRIES\t%d\t\n", d.Async.RetryAttempts)
+		_, _ = fmt.Fprintf(tab, "ASYNC MAX EVENT AGE\t%s\t\n", time.Duration(d.Async.MaxEventAge)*time.Second)
+		_, _ = fmt.Fprintf(tab, "ASYNC ON SUCCESS\t%s\t\n", orNone(d.Async.OnSuccess))
+		_, _ = fmt.Fprintf(tab, "ASYNC ON FAILURE\t%s\t\n", orNone(d.Async.OnFailure))
+		_, _ = fmt.Fprintf(tab, "DEAD LETTER QUEUE\t%s\t\n", orNone(d.Async.DeadLetterQueue))
+	}
+	if d.Concurrency != nil && d.Concurrency.Reserved != nil {
+		_, _ = fmt.Fprintf(tab, "RESERVED CONCURRENCY\t%d\t\n", *d.Concurrency.Reserved)
+	}
+	_ = tab.Flush()
+
+	fmt.Println()
+	tab = tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "ALIAS\tVERSION\tS3 KEY\tPROVISIONED\tURL\t\n")
+	for _, a := range d.Aliases {
+		url := ""
+		for _, s := range d.Stages {
+			if s.Name == a.Name {
+				url = s.Url
+			}
+		}
+		provisioned := ""
+		if d.Concurrency != nil {
+			for _, p := range d.Concurrency.Provisioned {
+				if p.Stage == a.Name {
+					provisioned = fmt.Sprintf("%d/%d %s", p.Allocated, p.Requested, p.Status)
+				}
+			}
+		}
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t%s\t\n", a.Name, a.Version, orNone(a.S3Key), orNone(provisioned), orNone(url))
+	}
+	_ = tab.Flush()
+
+	if len(d.Domains) > 0 {
+		fmt.Println()
+		tab = tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+		_, _ = fmt.Fprintf(tab, "DOMAIN\tBASE PATH\tSTAGE\tTARGET\t\n")
+		for _, domain := range d.Domains {
+			_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t\n", domain.Name, domain.BasePath, domain.Stage, domain.Target)
+		}
+		_ = tab.Flush()
+	}
+
+	if len(d.Triggers) > 0 {
+		fmt.Println()
+		tab = tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+		_, _ = fmt.Fprintf(tab, "TRIGGER\tSOURCE\tSTAGE\tSTATE\tDETAILS\t\n")
+		for _, t := range d.Triggers {
+			_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t%s\t\n", t.Kind, t.Source, t.Stage, t.State, t.Details)
+		}
+		_ = tab.Flush()
+	}
+
+	fmt.Println()
+	tab = tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "DEPLOYED AT\tSHA256 ID\tSIZE\tALIASES\t\n")
+	for _, h := range d.History {
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t\n", h.Time.Format(time.RFC822), h.Sha256[:12], util.HumanByteSize(h.Size), strings.Join(h.Aliases, " "))
+	}
+	_ = tab.Flush()
+	return nil
+}
+
+// orNone replace an empty value by a dash in tables
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	cmdDescribe := &cobra.Command{
+		Use:   "describe <name> <id>",
+		Short: "Describe the configuration, endpoints, triggers, role and deploys of a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  describe,
+	}
+	cmdDescribe.PersistentFlags().StringVar(&flDescribeStage, "stage", amazon.GatewayStage, "stage whose asynchronous invocations are described")
+	cmdDescribe.PersistentFlags().StringVarP(&flDescribeOutput, "output", "o", "table", "set the format of the description: table or json")
+
+	Root.AddCommand(cmdDescribe)
+}
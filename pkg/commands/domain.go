@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/spf13/cobra"
+)
+
+// flDomainCert is the acm certificate arn used to create the domain
+var flDomainCert string
+
+// flDomainBasePath is the base path under which the lambda is mapped
+var flDomainBasePath string
+
+// flDomainStage is the stage of the api the domain is mapped to
+var flDomainStage string
+
+func domainAdd(_ *cobra.Command, args []string) error {
+	resourceName := fmt.Sprintf("%s-%s", args[0], args[1])
+
+	var target string
+	if err := util.Action(fmt.Sprintf("Mapping domain %s to your lambda", args[2]), func() error {
+		var err error
+		target, err = amazon.DomainCreate(awsSession, resourceName, args[2], flDomainCert, flDomainBasePath, flDomainStage)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("Domain DNS target ", target)
+	return nil
+}
+
+func domainList(_ *cobra.Command, args []string) error {
+	list, err := amazon.DomainList(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]))
+	if err != nil {
+		return err
+	}
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "DOMAIN\tBASE PATH\tSTAGE\tDNS TARGET\t\n")
+	for _, d := range list {
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t\n", d.Name, d.BasePath, d.Stage, d.Target)
+	}
+	_ = tab.Flush()
+	return nil
+}
+
+func domainRemove(_ *cobra.Command, args []string) error {
+	return util.Action(fmt.Sprintf("Removing domain %s from your lambda", args[2]), func() error {
+		return amazon.DomainDelete(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]), args[2])
+	})
+}
+
+func init() {
+	cmdDomain := &cobra.Command{
+		Use:   "domain",
+		Short: "Manage custom domain names of a lambda",
+	}
+
+	cmdDomainAdd := &cobra.Command{
+		Use:   "add <name> <id> <domain>",
+		Short: "Map a custom domain to a lambda",
+		Args:  cobra.ExactArgs(3),
+		RunE:  domainAdd,
+	}
+	cmdDomainAdd.PersistentFlags().StringVar(&flDomainCert, "cert", "", "acm certificate arn used to create the domain")
+	cmdDomainAdd.PersistentFlags().StringVar(&flDomainBasePath, "base-path", "", "base path under which the lambda is mapped")
+	cmdDomainAdd.PersistentFlags().StringVar(&flDomainStage, "stage", amazon.GatewayStage, "stage of the api the domain is mapped to")
+
+	cmdDomainList := &cobra.Command{
+		Use:   "list <name> <id>",
+		Short: "List custom domains of a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  domainList,
+	}
+
+	cmdDomainRemove := &cobra.Command{
+		Use:   "remove <name> <id> <domain>",
+		Short: "Remove a custom domain from a lambda",
+		Args:  cobra.ExactArgs(3),
+		RunE:  domainRemove,
+	}
+
+	cmdDomain.AddCommand(cmdDomainAdd, cmdDomainList, cmdDomainRemove)
+	Root.AddCommand(cmdDomain)
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"aws-test/pkg/amazon"
+
+	"github.com/spf13/cobra"
+)
+
+// flGcYes delete the orphans without asking for confirmation
+var flGcYes bool
+
+func gc(_ *cobra.Command, _ []string) error {
+	orphans, err := amazon.OrphanList(awsSession)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("No orphan resources found")
+		return nil
+	}
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "KIND\tRESOURCE\t\n")
+	for _, o := range orphans {
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t\n", o.Kind, o.Resource)
+	}
+	_ = tab.Flush()
+	fmt.Println()
+
+	if !flGcYes {
+		fmt.Printf("Delete these %d resources? [y/N] ", len(orphans))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return nil
+		}
+	}
+
+	var removals []amazon.Removal
+	for _, o := range orphans {
+		removals = append(removals, amazon.OrphanRemove(awsSession, o)...)
+	}
+	if failed := printRemovals(removals); failed > 0 {
+		return fmt.Errorf("%d resources could not be removed", failed)
+	}
+	return nil
+}
+
+func init() {
+	cmdGc := &cobra.Command{
+		Use:   "gc",
+		Short: "Find and delete resources left behind by failed deploys or manual deletions",
+		Args:  cobra.NoArgs,
+		RunE:  gc,
+	}
+	cmdGc.PersistentFlags().BoolVarP(&flGcYes, "yes", "y", false, "delete the orphans without asking for confirmation")
+
+	Root.AddCommand(cmdGc)
+}
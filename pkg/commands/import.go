@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/spf13/cobra"
+)
+
+// flImportName set the name of the lambda, the rest of the function name is its id
+var flImportName string
+
+// importArgs refuse up front, with the usage, a function whose name can't be split into the name and id of a lambda
+func importArgs(cmd *cobra.Command, args []string) error {
+	if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+		return err
+	}
+	_, _, err := amazon.ImportId(args[0], flImportName)
+	return err
+}
+
+func importLambda(_ *cobra.Command, args []string) error {
+	functionName := args[0]
+
+	name, id, err := amazon.ImportId(functionName, flImportName)
+	if err != nil {
+		return err
+	}
+
+	var function *lambda.GetFunctionOutput
+	if err := util.Action(fmt.Sprintf("Checking function %s", functionName), func() error {
+		function, err = amazon.LambdaImportGet(awsSession, functionName)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	journal := &util.Journal{}
+	if err := importResources(functionName, function, journal); err != nil {
+		if rollbackErr := journal.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%v\n%v", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := util.Action(fmt.Sprintf("Tagging the resources of your lambda"), func() error {
+		return amazon.LambdaAdopt(awsSession, name, id)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("Lambda name ", name)
+	fmt.Println("Lambda id   ", id)
+	return nil
+}
+
+// importResources store the current code of the function as its first version and adopt its rest api
+func importResources(functionName string, function *lambda.GetFunctionOutput, journal *util.Journal) error {
+	var (
+		sum  string
+		file *os.File
+		err  error
+	)
+
+	if !amazon.S3BucketExist(awsSession, functionName) {
+		if err := util.Action(fmt.Sprintf("Creating bucket %s", functionName), func() error {
+			return amazon.S3CreateBucket(awsSession, functionName)
+		}); err != nil {
+			return err
+		}
+		journal.Record(fmt.Sprintf("bucket %s", functionName), func() error {
+			return amazon.S3DeleteBucket(awsSession, functionName)
+		})
+	}
+
+	if err := util.Action(fmt.Sprintf("Downloading the code of your lambda"), func() error {
+		sum, file, err = amazon.LambdaCodeDownload(function)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := util.Action(fmt.Sprintf("Uploading your lambda with sum %s to s3", sum), func() error {
+		if amazon.S3FileExist(awsSession, functionName, sum) {
+			return nil
+		}
+		_, _, err = amazon.S3UploadFile(awsSession, functionName, sum, file.Name())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var api *apigateway.RestApi
+	if err := util.Action(fmt.Sprintf("Looking for the api gateway of your lambda"), func() error {
+		api, err = amazon.GatewayAdopt(awsSession, functionName, *function.Configuration.FunctionArn)
+		return err
+	}); err != nil {
+		return err
+	}
+	if api != nil {
+		fmt.Println("Rest api    ", aws.StringValue(api.Name))
+	}
+	return nil
+}
+
+func init() {
+	cmdImport := &cobra.Command{
+		Use:   "import <name>-<id>",
+		Short: "Manage with awsl a lambda it did not create",
+		Long: `Manage with awsl a lambda it did not create.
+
+The function must be named <name>-<id>, --name tells where the name stops when it contains dashes. Every resource of
+the lambda is named after its function, so a function whose name is not of this form, or is not a valid bucket name
+(lowercase letters, digits and dashes, 3 to 63 characters), can't be imported: deploy it again under such a name.
+
+Its current code is stored as the first version and its role is left untouched. The rest api invoking it is renamed
+after the lambda, it is refused when it also integrates other backends since awsl deletes it with the lambda.`,
+		Args: importArgs,
+		RunE: importLambda,
+	}
+	cmdImport.PersistentFlags().StringVar(&flImportName, "name", "", "set the name of the lambda, the rest of the function name is its id")
+
+	Root.AddCommand(cmdImport)
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
@@ -58,13 +59,39 @@ func list(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
+	// The rest apis and schedule rules are listed once for all the lambdas
+	apis, err := amazon.GatewayIndex(awsSession)
+	if err != nil {
+		return err
+	}
+	rules, err := amazon.ScheduleRulesList(awsSession, "")
+	if err != nil {
+		return err
+	}
+
 	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
-	_, _ = fmt.Fprintf(tab, "NAME\tID\tRUNTIME\tMEMORY\tARN\t\n")
+	_, _ = fmt.Fprintf(tab, "NAME\tID\tRUNTIME\tMEMORY\tARN\tSTAGES\tSCHEDULE\t\n")
 
 	for _, f := range list {
 		split := strings.Split(*f.FunctionName, "-")
 		name := strings.Join(split[:len(split)-1], "-")
-		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t%s\t\n", name, split[len(split)-1], *f.Runtime, util.HumanByteSize(*f.MemorySize*1000000), *f.FunctionArn)
+
+		stages, err := amazon.StageListByApi(awsSession, *f.FunctionName, amazon.GatewayIndexGet(apis, *f.FunctionName))
+		if err != nil {
+			return err
+		}
+		var urls []string
+		for _, s := range stages {
+			urls = append(urls, s.Url)
+		}
+
+		var schedule []string
+		for stage, expression := range amazon.ScheduleOf(*f.FunctionName, rules) {
+			schedule = append(schedule, fmt.Sprintf("%s %s", stage, expression))
+		}
+		sort.Strings(schedule)
+
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name, split[len(split)-1], *f.Runtime, util.HumanByteSize(*f.MemorySize*1000000), *f.FunctionArn, strings.Join(urls, " "), strings.Join(schedule, ", "))
 	}
 	_ = tab.Flush()
 	return nil
@@ -76,7 +103,7 @@ func init() {
 		Short: "List of lambdas",
 		RunE:  list,
 	}
-	listCmd.PersistentFlags().BoolVarP(&flDeployForce, "all", "a", false, "list all lambdas even if awsl did not create them")
+	listCmd.PersistentFlags().BoolVarP(&flListAll, "all", "a", false, "list all lambdas even if awsl did not create them")
 
 	listVersion := &cobra.Command{
 		Use:   "list-version <name> <id>",
@@ -2,6 +2,8 @@ package commands
 
 import (
 	"fmt"
+	"os"
+	"text/tabwriter"
 
 	"aws-test/pkg/amazon"
 
@@ -11,18 +13,34 @@ import (
 var flRemoveStorage bool
 
 func remove(_ *cobra.Command, args []string) error {
-	if err := amazon.LambdaDelete(awsSession, fmt.Sprintf("%s-%s", args[0], args[1])); err != nil {
-		return err
-	}
-	if flRemoveStorage {
-		return amazon.S3DeleteBucket(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]))
+	removals := amazon.LambdaRemove(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]), flRemoveStorage)
+
+	if failed := printRemovals(removals); failed > 0 {
+		return fmt.Errorf("%d resources could not be removed, run remove again once fixed", failed)
 	}
 	return nil
 }
 
+// printRemovals show the outcome of each removal and return the number of failures
+func printRemovals(removals []amazon.Removal) int {
+	failed := 0
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "RESOURCE\tSTATUS\t\n")
+	for _, r := range removals {
+		status := r.Status
+		if r.Err != nil {
+			failed++
+			status = fmt.Sprintf("%s: %v", r.Status, r.Err)
+		}
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t\n", r.Resource, status)
+	}
+	_ = tab.Flush()
+	return failed
+}
+
 func init() {
 	cmdRemove := &cobra.Command{
-		Use:   "remove",
+		Use:   "remove <name> <id>",
 		Short: "Remove a lambda",
 		Args:  cobra.ExactArgs(2),
 		RunE:  remove,
@@ -15,6 +15,9 @@ import (
 // flRollbackTime use a time versioned sha256
 var flRollbackTime string
 
+// flRollbackStage is the stage to rollback
+var flRollbackStage string
+
 func rollback(_ *cobra.Command, args []string) error {
 	resourceName := fmt.Sprintf("%s-%s", args[0], args[1])
 	output, err := amazon.S3ListObjects(awsSession, resourceName)
@@ -60,8 +63,12 @@ func rollback(_ *cobra.Command, args []string) error {
 
 		split := strings.Split(*target.Key, "-")
 
-		if err := util.Action(fmt.Sprintf("Rollback to version %s", split[1][:len(split[1])-4]), func() error {
-			_, err := amazon.LambdaUpdateCode(awsSession, resourceName, *target.Key)
+		if err := util.Action(fmt.Sprintf("Rollback to version %s on stage %s", split[1][:len(split[1])-4], flRollbackStage), func() error {
+			cfg, err := amazon.LambdaUpdateCode(awsSession, resourceName, *target.Key)
+			if err != nil {
+				return err
+			}
+			_, err = amazon.LambdaAliasPut(awsSession, resourceName, flRollbackStage, *cfg.Version)
 			return err
 		}); err != nil {
 			return err
@@ -78,6 +85,7 @@ func init() {
 		RunE:  rollback,
 	}
 	cmdRollback.PersistentFlags().StringVarP(&flRollbackTime, "time", "t", "", "use a time versioned sha256")
+	cmdRollback.PersistentFlags().StringVar(&flRollbackStage, "stage", amazon.GatewayStage, "stage to rollback")
 
 	Root.AddCommand(cmdRollback)
 }
@@ -1,11 +1,25 @@
 package commands
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/manifest"
+	"aws-test/pkg/util"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/spf13/cobra"
 )
 
+// defaultRegion is the region used when none is given by the flags, the environment, the manifest or the profile
+const defaultRegion = "eu-west-3"
+
 type lambdaCtx struct {
 	folder, name, id string
 }
@@ -13,9 +27,33 @@ type lambdaCtx struct {
 // flRegion is the region to use
 var flRegion string
 
+// flProfile is the shared config profile to use
+var flProfile string
+
+// flAssumeRole is the arn of a role to assume
+var flAssumeRole string
+
+// flExternalId is the external id given when assuming the role
+var flExternalId string
+
+// flMfaSerial is the serial number of the mfa device required to assume the role
+var flMfaSerial string
+
+// flEndpointUrl replace the endpoint of every aws service, useful to target a local emulator
+var flEndpointUrl string
+
+// flManifest is the path of the manifest describing the lambda
+var flManifest string
+
+// awsContext is cancelled on interruption (Ctrl-C), every aws call made with awsSession stop with it
+var awsContext context.Context
+
 // awsSession is the aws session used
 var awsSession *session.Session
 
+// lambdaManifest is the manifest read from flManifest
+var lambdaManifest *manifest.Manifest
+
 var Root = &cobra.Command{
 	Use:   "awsl",
 	Short: "awls cli is the fastest and efficient way to deploy on aws",
@@ -24,7 +62,8 @@ Included:
  - Versions: using digest
  - Efficient storage: using s3 and zip your lambda
  - AWS Gateway setup`,
-	SilenceUsage: true,
+	SilenceUsage:      true,
+	PersistentPreRunE: newSession,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := cmd.Help(); err != nil {
 			return
@@ -32,14 +71,85 @@ Included:
 	},
 }
 
-func init() {
-	Root.PersistentFlags().StringVar(&flRegion, "region", "eu-west-3", "region to use")
+// newSession read the manifest and create the aws session once the flags are parsed.
+// The region is taken from, in order: --region, AWS_REGION, the manifest, the profile and finally defaultRegion.
+func newSession(cmd *cobra.Command, _ []string) error {
+	m, err := manifest.Load(flManifest)
+	if err != nil {
+		return err
+	}
+	lambdaManifest = m
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(flRegion),
+	ctx, cancel := context.WithCancel(context.Background())
+	interruptions := make(chan os.Signal, 1)
+	signal.Notify(interruptions, os.Interrupt)
+	go func() {
+		<-interruptions
+		cancel()
+		// A second interruption kill the process as usual
+		signal.Stop(interruptions)
+	}()
+	awsContext = ctx
+
+	cfg := aws.NewConfig()
+	cfg.Retryer = amazon.NewRetryer(util.NewBackoff(ctx, "aws call", nil).
+		WithStrategy(util.Exponential).
+		WithMaxAttempt(5).
+		WithBudget(2 * time.Minute).
+		WithOnRetry(util.ActionRetry))
+	switch {
+	case cmd.Flags().Changed("region"):
+		cfg.WithRegion(flRegion)
+	case os.Getenv("AWS_REGION") != "":
+		cfg.WithRegion(os.Getenv("AWS_REGION"))
+	case m.Region != "":
+		cfg.WithRegion(m.Region)
+	}
+	if flEndpointUrl != "" {
+		cfg.WithEndpoint(flEndpointUrl).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:                  *cfg,
+		Profile:                 flProfile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
 	})
 	if err != nil {
-		return
+		return err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(flRegion)
+	}
+
+	if flAssumeRole != "" {
+		credentials := stscreds.NewCredentials(sess, flAssumeRole, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = "awsl"
+			if flExternalId != "" {
+				p.ExternalID = aws.String(flExternalId)
+			}
+			if flMfaSerial != "" {
+				p.SerialNumber = aws.String(flMfaSerial)
+				p.TokenProvider = stscreds.StdinTokenProvider
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: credentials})
 	}
+
+	sess.Handlers.Validate.PushFront(func(r *request.Request) {
+		r.SetContext(awsContext)
+	})
+
 	awsSession = sess
+	return nil
+}
+
+func init() {
+	Root.PersistentFlags().StringVar(&flRegion, "region", defaultRegion, "region to use")
+	Root.PersistentFlags().StringVar(&flProfile, "profile", "", "shared config profile to use")
+	Root.PersistentFlags().StringVar(&flAssumeRole, "assume-role", "", "arn of a role to assume")
+	Root.PersistentFlags().StringVar(&flExternalId, "external-id", "", "external id given when assuming the role")
+	Root.PersistentFlags().StringVar(&flMfaSerial, "mfa-serial", "", "serial number of the mfa device required to assume the role, the code is prompted")
+	Root.PersistentFlags().StringVar(&flEndpointUrl, "endpoint-url", "", "replace the endpoint of every aws service, useful to target a local emulator")
+	Root.PersistentFlags().StringVar(&flManifest, "manifest", manifest.DefaultFile, "manifest describing the lambda")
 }
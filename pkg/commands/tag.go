@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"aws-test/pkg/amazon"
+	"aws-test/pkg/util"
+
+	"github.com/spf13/cobra"
+)
+
+func tag(_ *cobra.Command, args []string) error {
+	add := map[string]string{}
+	var remove []string
+	for _, arg := range args[2:] {
+		switch {
+		case strings.Contains(arg, "="):
+			kv := strings.SplitN(arg, "=", 2)
+			add[kv[0]] = kv[1]
+		case strings.HasSuffix(arg, "-"):
+			remove = append(remove, strings.TrimSuffix(arg, "-"))
+		default:
+			return fmt.Errorf("invalid tag %q, use key=value to set it or key- to remove it", arg)
+		}
+	}
+
+	keys := append([]string{}, remove...)
+	for k := range add {
+		keys = append(keys, k)
+	}
+	if err := amazon.TagsCheck(keys); err != nil {
+		return err
+	}
+
+	return util.Action("Tagging the resources of your lambda", func() error {
+		return amazon.TagsApply(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]), add, remove)
+	})
+}
+
+func init() {
+	cmdTag := &cobra.Command{
+		Use:   "tag <name> <id> <key=value|key->...",
+		Short: "Set or remove tags on every resource of a lambda",
+		Args:  cobra.MinimumNArgs(3),
+		RunE:  tag,
+	}
+
+	Root.AddCommand(cmdTag)
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"aws-test/pkg/amazon"
+
+	"github.com/spf13/cobra"
+)
+
+func triggers(_ *cobra.Command, args []string) error {
+	list, err := amazon.TriggersList(awsSession, fmt.Sprintf("%s-%s", args[0], args[1]))
+	if err != nil {
+		return err
+	}
+
+	tab := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	_, _ = fmt.Fprintf(tab, "KIND\tSOURCE\tSTAGE\tSTATE\tDETAILS\t\n")
+	for _, t := range list {
+		_, _ = fmt.Fprintf(tab, "%s\t%s\t%s\t%s\t%s\t\n", t.Kind, t.Source, t.Stage, t.State, t.Details)
+	}
+	_ = tab.Flush()
+	return nil
+}
+
+func init() {
+	cmdTriggers := &cobra.Command{
+		Use:   "triggers <name> <id>",
+		Short: "List the schedule, queues, streams, buckets and topics invoking a lambda",
+		Args:  cobra.ExactArgs(2),
+		RunE:  triggers,
+	}
+
+	Root.AddCommand(cmdTriggers)
+}
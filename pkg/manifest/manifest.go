@@ -0,0 +1,450 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultFile is the manifest read when none is specified
+const DefaultFile = "awsl.yml"
+
+var stageNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+// Authentication required to call the api of the lambda
+const (
+	AuthNone   = "none"
+	AuthIam    = "iam"
+	AuthApiKey = "apikey"
+)
+
+// ScheduleNone remove the schedule of the lambda, without a schedule the current one is kept
+const ScheduleNone = "none"
+
+// Manifest describe how a lambda is deployed, flags given to the cli take precedence over it
+type Manifest struct {
+	Region  string            `yaml:"region"`
+	Runtime string            `yaml:"runtime"`
+	Tags    map[string]string `yaml:"tags"`
+	Auth    string            `yaml:"auth"`
+	Cors    *Cors             `yaml:"cors"`
+
+	Authorizer *Authorizer `yaml:"authorizer"`
+
+	Role                string            `yaml:"role"`
+	Policies            []string          `yaml:"policies"`
+	InlinePolicies      map[string]string `yaml:"inline-policies"`
+	PermissionsBoundary string            `yaml:"permissions-boundary"`
+
+	Stage  string            `yaml:"stage"`
+	Stages map[string]*Stage `yaml:"stages"`
+
+	Schedule      string `yaml:"schedule"`
+	ScheduleInput string `yaml:"schedule-input"`
+
+	Triggers *Triggers `yaml:"triggers"`
+
+	Async *Async `yaml:"async"`
+
+	Concurrency *Concurrency `yaml:"concurrency"`
+}
+
+// Cors is the cross origin configuration of the api of the lambda
+type Cors struct {
+	Origins     []string `yaml:"origins"`
+	Methods     []string `yaml:"methods"`
+	Headers     []string `yaml:"headers"`
+	MaxAge      int64    `yaml:"max-age"`
+	Credentials bool     `yaml:"credentials"`
+}
+
+// Authorizer is a lambda protecting the api of the lambda, either an awsl lambda (name and id) or any lambda (arn)
+type Authorizer struct {
+	Name           string `yaml:"name"`
+	Id             string `yaml:"id"`
+	Arn            string `yaml:"arn"`
+	Type           string `yaml:"type"`
+	IdentitySource string `yaml:"identity-source"`
+	Ttl            int64  `yaml:"ttl"`
+}
+
+// Stage is the configuration of a stage of the api, each stage invoke the alias of the lambda named after it
+type Stage struct {
+	Throttle  *Throttle `yaml:"throttle"`
+	Logging   string    `yaml:"logging"`
+	DataTrace bool      `yaml:"data-trace"`
+	Metrics   bool      `yaml:"metrics"`
+}
+
+// Throttle limit the requests per second and the concurrent requests
+type Throttle struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int64   `yaml:"burst"`
+}
+
+// Triggers are the event sources invoking the alias of the stage besides the api
+type Triggers struct {
+	Sqs     []*Sqs    `yaml:"sqs"`
+	S3      []*S3     `yaml:"s3"`
+	Streams []*Stream `yaml:"streams"`
+	Sns     []*Sns    `yaml:"sns"`
+}
+
+// Sqs is a queue consumed by the lambda through an event source mapping
+type Sqs struct {
+	Queue                string `yaml:"queue"`
+	BatchSize            int64  `yaml:"batch-size"`
+	BatchingWindow       int64  `yaml:"batching-window"`
+	MaxConcurrency       int64  `yaml:"max-concurrency"`
+	PartialBatchResponse bool   `yaml:"partial-batch-response"`
+}
+
+// S3 is a bucket notifying the lambda of the events on its objects matching the prefix and suffix
+type S3 struct {
+	Bucket string   `yaml:"bucket"`
+	Events []string `yaml:"events"`
+	Prefix string   `yaml:"prefix"`
+	Suffix string   `yaml:"suffix"`
+}
+
+// Stream is a dynamodb or kinesis stream read by the lambda through an event source mapping, records failing after
+// the retries are sent to the on failure destination, a sqs queue or a sns topic
+type Stream struct {
+	Arn              string `yaml:"arn"`
+	StartingPosition string `yaml:"starting-position"`
+	BatchSize        int64  `yaml:"batch-size"`
+	BisectOnError    bool   `yaml:"bisect-on-error"`
+	RetryAttempts    *int64 `yaml:"retry-attempts"`
+	MaxRecordAge     int64  `yaml:"max-record-age"`
+	OnFailure        string `yaml:"on-failure"`
+}
+
+// Sns is a topic the lambda is subscribed to, only the messages matching the filter policy are delivered when one is
+// given
+type Sns struct {
+	Topic        string `yaml:"topic"`
+	FilterPolicy string `yaml:"filter-policy"`
+}
+
+// Async is how asynchronous invocations are retried and where their results are sent, events still failing after the
+// retries go to the on failure destination and the dead letter queue
+type Async struct {
+	RetryAttempts   *int64       `yaml:"retry-attempts"`
+	MaxEventAge     int64        `yaml:"max-event-age"`
+	OnSuccess       *Destination `yaml:"on-success"`
+	OnFailure       *Destination `yaml:"on-failure"`
+	DeadLetterQueue string       `yaml:"dead-letter-queue"`
+}
+
+// Destination receive the result of asynchronous invocations, either an awsl lambda (name and id) or the arn of a sqs
+// queue, a sns topic, a lambda or an event bus
+type Destination struct {
+	Name string `yaml:"name"`
+	Id   string `yaml:"id"`
+	Arn  string `yaml:"arn"`
+}
+
+// Concurrency cap the concurrent executions of the function and keep instances of its aliases initialized, the
+// provisioned concurrency of an alias can be scaled on a schedule
+type Concurrency struct {
+	Reserved    *int64                 `yaml:"reserved"`
+	Provisioned map[string]int64       `yaml:"provisioned"`
+	Schedules   []*ConcurrencySchedule `yaml:"schedules"`
+}
+
+// ConcurrencySchedule set the provisioned concurrency of the alias of a stage between min and max on a cron(...),
+// rate(...) or at(...) expression
+type ConcurrencySchedule struct {
+	Name     string `yaml:"name"`
+	Stage    string `yaml:"stage"`
+	Schedule string `yaml:"schedule"`
+	Min      int64  `yaml:"min"`
+	Max      int64  `yaml:"max"`
+}
+
+// Load read the manifest at path, an empty manifest is returned if the file does not exist
+func Load(path string) (*Manifest, error) {
+	m := &Manifest{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate check the values that can't be checked by the yaml decoding and fill the defaults
+func (m *Manifest) Validate() error {
+	switch m.Auth {
+	case "", AuthNone, AuthIam, AuthApiKey:
+	default:
+		return fmt.Errorf("invalid auth %q, must be one of %s, %s or %s", m.Auth, AuthNone, AuthIam, AuthApiKey)
+	}
+
+	if !stageNameRegexp.MatchString(m.Stage) {
+		return fmt.Errorf("invalid stage %q, only letters, digits and underscores are allowed", m.Stage)
+	}
+	for name, stage := range m.Stages {
+		switch strings.ToUpper(stage.Logging) {
+		case "", "OFF", "ERROR", "INFO":
+		default:
+			return fmt.Errorf("invalid logging %q of stage %s, must be OFF, ERROR or INFO", stage.Logging, name)
+		}
+	}
+
+	// Browsers refuse credentials from an api allowing any origin
+	if c := m.Cors; c != nil && c.Credentials {
+		if len(c.Origins) == 0 {
+			return errors.New("cors credentials need the allowed origins")
+		}
+		for _, o := range c.Origins {
+			if o == "*" {
+				return errors.New("cors credentials can't be allowed with the * origin")
+			}
+		}
+	}
+
+	if m.Role != "" && (len(m.Policies) > 0 || len(m.InlinePolicies) > 0 || m.PermissionsBoundary != "") {
+		return errors.New("policies can't be set on an existing role")
+	}
+
+	if m.Schedule != "" && m.Schedule != ScheduleNone && (!(strings.HasPrefix(m.Schedule, "rate(") || strings.HasPrefix(m.Schedule, "cron(")) ||
+		!strings.HasSuffix(m.Schedule, ")")) {
+		return fmt.Errorf("invalid schedule %q, must be rate(...), cron(...) or %s", m.Schedule, ScheduleNone)
+	}
+	if m.ScheduleInput != "" {
+		if m.Schedule == "" {
+			return errors.New("a schedule input needs a schedule")
+		}
+		if !json.Valid([]byte(m.ScheduleInput)) {
+			return fmt.Errorf("invalid schedule input %q, must be json", m.ScheduleInput)
+		}
+	}
+
+	if m.Triggers != nil {
+		if err := m.Triggers.validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.Async != nil {
+		if err := m.Async.validate(); err != nil {
+			return err
+		}
+	}
+
+	if m.Concurrency != nil {
+		if err := m.Concurrency.validate(); err != nil {
+			return err
+		}
+	}
+
+	if a := m.Authorizer; a != nil {
+		if m.Auth == AuthIam {
+			return errors.New("an authorizer can't be used with iam auth")
+		}
+		if a.Arn == "" && (a.Name == "" || a.Id == "") {
+			return errors.New("authorizer needs either an arn or the name and id of an awsl lambda")
+		}
+		if a.Type == "" {
+			a.Type = "TOKEN"
+		}
+		if a.Type != "TOKEN" && a.Type != "REQUEST" {
+			return fmt.Errorf("invalid authorizer type %q, must be TOKEN or REQUEST", a.Type)
+		}
+		if a.IdentitySource == "" {
+			a.IdentitySource = "method.request.header.Authorization"
+		}
+		if a.Ttl == 0 {
+			a.Ttl = 300
+		}
+	}
+	return nil
+}
+
+func (t *Triggers) validate() error {
+	queues := map[string]bool{}
+	for _, q := range t.Sqs {
+		if q.Queue == "" {
+			return errors.New("a sqs trigger needs a queue, given by its name or arn")
+		}
+		if queues[q.Queue] {
+			return fmt.Errorf("queue %s is consumed twice", q.Queue)
+		}
+		queues[q.Queue] = true
+
+		if q.BatchSize == 0 {
+			q.BatchSize = 10
+		}
+		if q.BatchSize < 1 || q.BatchSize > 10000 {
+			return fmt.Errorf("invalid batch size %d of queue %s, must be between 1 and 10000", q.BatchSize, q.Queue)
+		}
+		if q.BatchingWindow < 0 || q.BatchingWindow > 300 {
+			return fmt.Errorf("invalid batching window %d of queue %s, must be between 0 and 300 seconds", q.BatchingWindow, q.Queue)
+		}
+		if q.BatchSize > 10 && q.BatchingWindow == 0 {
+			return fmt.Errorf("queue %s needs a batching window to use a batch size above 10", q.Queue)
+		}
+		if q.MaxConcurrency != 0 && (q.MaxConcurrency < 2 || q.MaxConcurrency > 1000) {
+			return fmt.Errorf("invalid max concurrency %d of queue %s, must be between 2 and 1000", q.MaxConcurrency, q.Queue)
+		}
+	}
+
+	for _, b := range t.S3 {
+		if b.Bucket == "" {
+			return errors.New("a s3 trigger needs a bucket")
+		}
+		if len(b.Events) == 0 {
+			b.Events = []string{"s3:ObjectCreated:*"}
+		}
+		for _, e := range b.Events {
+			if !strings.HasPrefix(e, "s3:") {
+				return fmt.Errorf("invalid event %q of bucket %s, must be like s3:ObjectCreated:*", e, b.Bucket)
+			}
+		}
+	}
+
+	streams := map[string]bool{}
+	for _, st := range t.Streams {
+		if !strings.HasPrefix(st.Arn, "arn:") || !(strings.Contains(st.Arn, ":kinesis:") ||
+			strings.Contains(st.Arn, ":dynamodb:") && strings.Contains(st.Arn, "/stream/")) {
+			return fmt.Errorf("invalid stream %q, must be the arn of a dynamodb or kinesis stream", st.Arn)
+		}
+		if streams[st.Arn] {
+			return fmt.Errorf("stream %s is read twice", st.Arn)
+		}
+		streams[st.Arn] = true
+
+		if st.StartingPosition == "" {
+			st.StartingPosition = "LATEST"
+		}
+		if st.StartingPosition != "LATEST" && st.StartingPosition != "TRIM_HORIZON" {
+			return fmt.Errorf("invalid starting position %q of stream %s, must be LATEST or TRIM_HORIZON", st.StartingPosition, st.Arn)
+		}
+		if st.BatchSize == 0 {
+			st.BatchSize = 100
+		}
+		if st.BatchSize < 1 || st.BatchSize > 10000 {
+			return fmt.Errorf("invalid batch size %d of stream %s, must be between 1 and 10000", st.BatchSize, st.Arn)
+		}
+		if st.RetryAttempts == nil {
+			retries := int64(-1)
+			st.RetryAttempts = &retries
+		}
+		if *st.RetryAttempts < -1 || *st.RetryAttempts > 10000 {
+			return fmt.Errorf("invalid retry attempts %d of stream %s, must be between 0 and 10000 or -1 to retry until the record expires", *st.RetryAttempts, st.Arn)
+		}
+		if st.MaxRecordAge == 0 {
+			st.MaxRecordAge = -1
+		}
+		if st.MaxRecordAge != -1 && (st.MaxRecordAge < 60 || st.MaxRecordAge > 604800) {
+			return fmt.Errorf("invalid max record age %d of stream %s, must be between 60 and 604800 seconds or -1", st.MaxRecordAge, st.Arn)
+		}
+		if st.OnFailure != "" && !(strings.HasPrefix(st.OnFailure, "arn:") &&
+			(strings.Contains(st.OnFailure, ":sqs:") || strings.Contains(st.OnFailure, ":sns:"))) {
+			return fmt.Errorf("invalid on failure destination %q of stream %s, must be the arn of a sqs queue or a sns topic", st.OnFailure, st.Arn)
+		}
+	}
+
+	topics := map[string]bool{}
+	for _, sns := range t.Sns {
+		if !strings.HasPrefix(sns.Topic, "arn:") || !strings.Contains(sns.Topic, ":sns:") {
+			return fmt.Errorf("invalid topic %q, must be the arn of a sns topic", sns.Topic)
+		}
+		if topics[sns.Topic] {
+			return fmt.Errorf("topic %s is subscribed twice", sns.Topic)
+		}
+		topics[sns.Topic] = true
+		if sns.FilterPolicy != "" && !json.Valid([]byte(sns.FilterPolicy)) {
+			return fmt.Errorf("invalid filter policy of topic %s, must be json", sns.Topic)
+		}
+	}
+	return nil
+}
+
+func (a *Async) validate() error {
+	if a.RetryAttempts == nil {
+		retries := int64(2)
+		a.RetryAttempts = &retries
+	}
+	if *a.RetryAttempts < 0 || *a.RetryAttempts > 2 {
+		return fmt.Errorf("invalid async retry attempts %d, must be between 0 and 2", *a.RetryAttempts)
+	}
+	if a.MaxEventAge == 0 {
+		a.MaxEventAge = 21600
+	}
+	if a.MaxEventAge < 60 || a.MaxEventAge > 21600 {
+		return fmt.Errorf("invalid async max event age %d, must be between 60 and 21600 seconds", a.MaxEventAge)
+	}
+
+	for name, d := range map[string]*Destination{"on success": a.OnSuccess, "on failure": a.OnFailure} {
+		if d == nil {
+			continue
+		}
+		if d.Arn == "" && (d.Name == "" || d.Id == "") {
+			return fmt.Errorf("async %s destination needs either an arn or the name and id of an awsl lambda", name)
+		}
+		if d.Arn != "" && !(strings.HasPrefix(d.Arn, "arn:") && (strings.Contains(d.Arn, ":sqs:") ||
+			strings.Contains(d.Arn, ":sns:") || strings.Contains(d.Arn, ":lambda:") || strings.Contains(d.Arn, ":events:"))) {
+			return fmt.Errorf("invalid async %s destination %q, must be the arn of a sqs queue, a sns topic, a lambda or an event bus", name, d.Arn)
+		}
+	}
+
+	if a.DeadLetterQueue != "" && !(strings.HasPrefix(a.DeadLetterQueue, "arn:") &&
+		(strings.Contains(a.DeadLetterQueue, ":sqs:") || strings.Contains(a.DeadLetterQueue, ":sns:"))) {
+		return fmt.Errorf("invalid dead letter queue %q, must be the arn of a sqs queue or a sns topic", a.DeadLetterQueue)
+	}
+	return nil
+}
+
+var scheduleNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func (c *Concurrency) validate() error {
+	if c.Reserved != nil && *c.Reserved < -1 {
+		return fmt.Errorf("invalid reserved concurrency %d, must be positive or -1 to remove the cap", *c.Reserved)
+	}
+	for stage, n := range c.Provisioned {
+		if !stageNameRegexp.MatchString(stage) {
+			return fmt.Errorf("invalid stage %q of provisioned concurrency, only letters, digits and underscores are allowed", stage)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid provisioned concurrency %d of stage %s, must be positive", n, stage)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, s := range c.Schedules {
+		if !scheduleNameRegexp.MatchString(s.Name) {
+			return fmt.Errorf("invalid concurrency schedule name %q, only letters, digits, dashes and underscores are allowed", s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("concurrency schedule %s is defined twice", s.Name)
+		}
+		names[s.Name] = true
+
+		if c.Provisioned[s.Stage] == 0 {
+			return fmt.Errorf("concurrency schedule %s needs a provisioned concurrency on stage %q", s.Name, s.Stage)
+		}
+		if !(strings.HasPrefix(s.Schedule, "cron(") || strings.HasPrefix(s.Schedule, "rate(") ||
+			strings.HasPrefix(s.Schedule, "at(")) || !strings.HasSuffix(s.Schedule, ")") {
+			return fmt.Errorf("invalid schedule %q of concurrency schedule %s, must be cron(...), rate(...) or at(...)", s.Schedule, s.Name)
+		}
+		if s.Min < 0 || s.Max < 1 || s.Min > s.Max {
+			return fmt.Errorf("invalid min %d and max %d of concurrency schedule %s", s.Min, s.Max, s.Name)
+		}
+	}
+	return nil
+}
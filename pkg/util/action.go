@@ -14,3 +14,8 @@ func Action(actionMessage string, f func() error) error {
 	}
 	return nil
 }
+
+// ActionRetry show that the running action failed and is retried, it is meant to be given to Backoff.WithOnRetry
+func ActionRetry(attempt, maxAttempt int, err error) {
+	fmt.Printf("  retrying (%d/%d)… %v\n", attempt, maxAttempt, err)
+}
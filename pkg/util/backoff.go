@@ -1,38 +1,66 @@
 package util
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
 	"time"
 )
 
 const (
 	DefaultMaxRetry = 10
 	DefaultInterval = 500 * time.Millisecond
+	DefaultMaxDelay = 20 * time.Second
+)
+
+// Strategy is how the delay between two attempts grows
+type Strategy int
+
+const (
+	Fibonacci Strategy = iota
+	Exponential
 )
 
 type Backoff struct {
+	ctx         context.Context
 	description string
 	maxAttempt  int
 	attempt     int
 	function    func() error
 	interval    time.Duration
+	maxDelay    time.Duration
+	budget      time.Duration
+	strategy    Strategy
+	retryable   func(error) bool
+	onRetry     func(attempt, maxAttempt int, err error)
 }
 
-func NewBackoff(description string, run func() error) *Backoff {
+func NewBackoff(ctx context.Context, description string, run func() error) *Backoff {
 	return &Backoff{
+		ctx:         ctx,
 		description: description,
 		maxAttempt:  DefaultMaxRetry,
 		attempt:     1,
 		function:    run,
 		interval:    DefaultInterval,
+		maxDelay:    DefaultMaxDelay,
+		strategy:    Fibonacci,
 	}
 }
 
 func (s *Backoff) Clone() *Backoff {
 	return &Backoff{
-		maxAttempt: s.maxAttempt,
-		attempt:    s.attempt,
-		interval:   s.interval,
-		function:   s.function,
+		ctx:         s.ctx,
+		description: s.description,
+		maxAttempt:  s.maxAttempt,
+		attempt:     s.attempt,
+		function:    s.function,
+		interval:    s.interval,
+		maxDelay:    s.maxDelay,
+		budget:      s.budget,
+		strategy:    s.strategy,
+		retryable:   s.retryable,
+		onRetry:     s.onRetry,
 	}
 }
 
@@ -46,17 +74,107 @@ func (s *Backoff) WithInterval(interval time.Duration) *Backoff {
 	return s
 }
 
+// WithMaxDelay cap the delay between two attempts
+func (s *Backoff) WithMaxDelay(maxDelay time.Duration) *Backoff {
+	s.maxDelay = maxDelay
+	return s
+}
+
+// WithBudget stop retrying when the next attempt would start after the budget is elapsed, 0 means no budget
+func (s *Backoff) WithBudget(budget time.Duration) *Backoff {
+	s.budget = budget
+	return s
+}
+
+func (s *Backoff) WithStrategy(strategy Strategy) *Backoff {
+	s.strategy = strategy
+	return s
+}
+
+// WithRetryable set the classifier telling which errors are worth a retry, the others are returned immediately.
+// Without classifier every error is retried.
+func (s *Backoff) WithRetryable(retryable func(error) bool) *Backoff {
+	s.retryable = retryable
+	return s
+}
+
+// WithOnRetry set the callback called with the number of the next attempt before waiting for it
+func (s *Backoff) WithOnRetry(onRetry func(attempt, maxAttempt int, err error)) *Backoff {
+	s.onRetry = onRetry
+	return s
+}
+
+// MaxAttempt return the number of attempts made before giving up
+func (s *Backoff) MaxAttempt() int {
+	return s.maxAttempt
+}
+
+// Budget return the total time allowed to the attempts, 0 means no budget
+func (s *Backoff) Budget() time.Duration {
+	return s.budget
+}
+
+// NotifyRetry call the retry callback, for retries not driven by Execute
+func (s *Backoff) NotifyRetry(attempt int, err error) {
+	if s.onRetry != nil {
+		s.onRetry(attempt, s.maxAttempt, err)
+	}
+}
+
+// Delay return the time to wait after the failed attempt, a random duration between 0 and the delay of the strategy
+// capped by the max delay (full jitter)
+func (s *Backoff) Delay(attempt int) time.Duration {
+	var factor int64
+	switch s.strategy {
+	case Exponential:
+		factor = 1 << uint(attempt-1)
+	default:
+		factor = int64(fibonacci(attempt))
+	}
+
+	delay := time.Duration(factor) * s.interval
+	if delay > s.maxDelay || delay <= 0 {
+		delay = s.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
 func (s *Backoff) Execute() error {
+	start := time.Now()
 	for {
-		if err := s.function(); err != nil {
-			time.Sleep(time.Duration(fibonacci(s.attempt)) * s.interval)
-			if s.attempt == s.maxAttempt {
-				return err
-			}
-			s.attempt++
-			continue
+		if err := s.ctx.Err(); err != nil {
+			return fmt.Errorf("%s: %v", s.description, err)
+		}
+
+		err := s.function()
+		if err == nil {
+			return nil
+		}
+		if s.retryable != nil && !s.retryable(err) {
+			return fmt.Errorf("%s: %v", s.description, err)
+		}
+		if s.attempt >= s.maxAttempt {
+			return fmt.Errorf("%s: still failing after %d attempts: %v", s.description, s.maxAttempt, err)
+		}
+
+		delay := s.Delay(s.attempt)
+		if s.budget > 0 && time.Since(start)+delay > s.budget {
+			return fmt.Errorf("%s: still failing after %s: %v", s.description, s.budget, err)
+		}
+
+		s.NotifyRetry(s.attempt+1, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%s: %v", s.description, s.ctx.Err())
+		case <-timer.C:
 		}
-		return nil
+		s.attempt++
 	}
 }
 
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Journal record how to undo each resource created by an operation, so a failure in the middle of it leave nothing
+// behind. A nil journal record nothing.
+type Journal struct {
+	entries []journalEntry
+}
+
+type journalEntry struct {
+	description string
+	undo        func() error
+}
+
+// Record add a created resource to the journal
+func (j *Journal) Record(description string, undo func() error) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{description: description, undo: undo})
+}
+
+// Descriptions return the descriptions of the recorded resources in creation order
+func (j *Journal) Descriptions() []string {
+	var list []string
+	for _, e := range j.entries {
+		list = append(list, e.description)
+	}
+	return list
+}
+
+// Rollback undo the recorded resources in reverse order, an undo failing does not stop the others
+func (j *Journal) Rollback() error {
+	var failures []string
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		e := j.entries[i]
+		if err := Action(fmt.Sprintf("Removing %s", e.description), e.undo); err != nil {
+			fmt.Printf("\n  FAILED %v\n\n", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", e.description, err))
+		}
+	}
+	j.entries = nil
+
+	if len(failures) > 0 {
+		return fmt.Errorf("some resources could not be removed:\n - %s", strings.Join(failures, "\n - "))
+	}
+	return nil
+}